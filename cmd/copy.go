@@ -0,0 +1,84 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"github.com/fkautz/sentry/sentrylib"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+)
+
+var copyFrom string
+var copyTo string
+
+// copyCmd represents the db copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Copy all data from one database to another",
+	Long: `Copies live and dead nodes, registered emails, cutoffs, node metadata
+and history from the database configured in --from to the one configured in
+--to. Both flags name sentry config files; only their database settings are
+used.
+
+Stop sentry before copying so no updates are missed. Records already in the
+destination are overwritten for every callsign found in the source.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if copyFrom == "" || copyTo == "" {
+			log.Fatalln("Both --from and --to are required")
+		}
+		src, err := openStoreFromConfig(copyFrom)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer src.Close()
+		dst, err := openStoreFromConfig(copyTo)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer dst.Close()
+
+		stats, err := sentry_store.Copy(dst, src)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Copied %d live, %d dead, %d emails, %d cutoffs, %d nodes, %d history events, %d tokens and %d audit entries\n",
+			stats.Live, stats.Dead, stats.Emails, stats.Cutoffs, stats.Nodes, stats.Events, stats.Tokens, stats.Audit)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().StringVar(&copyFrom, "from", "", "config file of the source database")
+	copyCmd.Flags().StringVar(&copyTo, "to", "", "config file of the destination database")
+}
+
+// openStoreFromConfig opens the database configured in the config file at
+// path, independently of the global --config.
+func openStoreFromConfig(path string) (sentry_store.Store, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, errors.New("Unable to read " + path + ": " + err.Error())
+	}
+	config := sentrylib.Config{}
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, err
+	}
+	return sentrylib.OpenStore(config)
+}
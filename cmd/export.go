@@ -0,0 +1,70 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/fkautz/sentry/sentrylib"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var exportOutput string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Dump the whole database as JSON Lines",
+	Long: `Writes live and dead nodes, registered emails, cutoffs, node metadata,
+history, API token hashes and the audit log from the configured database in
+the versioned sentry-export JSON Lines format, which "sentry import" reads
+back into any storage backend.
+
+The first line is a header naming the format and version; every following
+line is one record with a "type" of live, dead, email, cutoff, node, event,
+token or audit. See ExportVersion in sentrylib/sentry_store/export.go for
+the full description.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := sentrylib.OpenStore(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer store.Close()
+
+		out := os.Stdout
+		if exportOutput != "" && exportOutput != "-" {
+			out, err = os.OpenFile(exportOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		stats, err := sentry_store.Export(out, store)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Exported %d live, %d dead, %d emails, %d cutoffs, %d nodes, %d history events, %d tokens and %d audit entries\n",
+			stats.Live, stats.Dead, stats.Emails, stats.Cutoffs, stats.Nodes, stats.Events, stats.Tokens, stats.Audit)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write, default stdout")
+}
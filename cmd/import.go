@@ -0,0 +1,66 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/fkautz/sentry/sentrylib"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var importInput string
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Load a JSON Lines export into the database",
+	Long: `Reads a file written by "sentry export" into the configured database.
+Records for callsigns that already exist are overwritten, and imported history
+replaces the history stored for the same callsign.
+
+Stop sentry before importing so no updates are lost.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		in := os.Stdin
+		if importInput != "" && importInput != "-" {
+			var err error
+			in, err = os.Open(importInput)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer in.Close()
+		}
+
+		store, err := sentrylib.OpenStore(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer store.Close()
+
+		stats, err := sentry_store.Import(in, store)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Imported %d live, %d dead, %d emails, %d cutoffs, %d nodes, %d history events, %d tokens and %d audit entries\n",
+			stats.Live, stats.Dead, stats.Emails, stats.Cutoffs, stats.Nodes, stats.Events, stats.Tokens, stats.Audit)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVarP(&importInput, "input", "i", "", "file to read, default stdin")
+}
@@ -0,0 +1,45 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/fkautz/sentry/sentrylib"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+// migrateCmd represents the db migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending database schema migrations",
+	Long: `Brings the configured Postgres database up to the schema version this
+build expects. "sentry serve" does the same on startup unless
+PostgresConfig.DisableAutoMigrate is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, to, err := sentrylib.MigrateDatabase(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if from == to {
+			log.Println("Database schema is up to date at version", to)
+		} else {
+			log.Println("Migrated database schema from version", from, "to", to)
+		}
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(migrateCmd)
+}
@@ -67,7 +67,7 @@ func init() {
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	fmt.Println("cfgFile: " + cfgFile)
+	fmt.Fprintln(os.Stderr, "cfgFile: "+cfgFile)
 	if cfgFile != "" { // enable ability to specify config file via flag
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -79,7 +79,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 
 	cfg = &sentrylib.Config{}
@@ -87,7 +87,7 @@ func initConfig() {
 
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("Config file changed", e)
+		fmt.Fprintln(os.Stderr, "Config file changed", e)
 		viper.Unmarshal(cfg)
 	})
 }
@@ -15,9 +15,13 @@
 package cmd
 
 import (
+	"context"
 	"github.com/fkautz/sentry/sentrylib"
 	"github.com/spf13/cobra"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 // serveCmd represents the serve command
@@ -32,8 +36,18 @@ This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.SetFlags(log.Flags() | log.Lshortfile)
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Println("Received", sig, "- shutting down")
+			cancel()
+			signal.Stop(signals)
+		}()
+
 		sentry := sentrylib.NewSentry(*cfg)
-		err := sentry.Serve()
+		err := sentry.Serve(ctx)
 		if err != nil {
 			log.Fatalln(err)
 		}
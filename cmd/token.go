@@ -0,0 +1,152 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/fkautz/sentry/sentrylib"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var tokenScope string
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for the admin API",
+	Long: `Creates, lists and revokes the bearer tokens the admin API accepts.
+Tokens with the read scope may only use GET requests; tokens with the manage
+scope may also change emails and cutoffs. Every change is recorded in the
+audit log under the token's name.
+
+The bolt and goleveldb backends lock their files, so these commands fail
+while sentry is running against them. Either stop sentry first or use the
+admin API instead: GET /tokens, POST /tokens with {"name": ..., "scope": ...}
+and DELETE /tokens/<name>, with a manage token or from localhost.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a token and print its secret",
+	Long: `Creates a token and prints its secret. Only a hash of the secret is
+stored, so save it now; it cannot be shown again. Send it to the admin API
+as "Authorization: Bearer <secret>".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expected exactly one token name")
+		}
+		scope, err := sentrylib.ParseTokenScope(tokenScope)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		token, secret, err := sentrylib.NewApiToken(args[0], scope)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		store, err := sentrylib.OpenStore(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer store.Close()
+		if _, ok, err := store.GetToken(token.Name); err != nil {
+			log.Fatalln(err)
+		} else if ok {
+			log.Fatalln("Token '" + token.Name + "' already exists, revoke it first")
+		}
+		if err := store.AddToken(token); err != nil {
+			log.Fatalln(err)
+		}
+		addCliAudit(store, "token.create", token.Name, string(token.Scope))
+		fmt.Println(secret)
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := sentrylib.OpenStore(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer store.Close()
+		tokens, err := store.ListTokens()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSCOPE\tCREATED")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", token.Name, token.Scope, token.Created.UTC().Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke a token",
+	Long:  `Deletes a token. Requests using it are rejected from then on.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expected exactly one token name")
+		}
+		store, err := sentrylib.OpenStore(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer store.Close()
+		if _, ok, err := store.GetToken(args[0]); err != nil {
+			log.Fatalln(err)
+		} else if !ok {
+			log.Fatalln("No token named '" + args[0] + "'")
+		}
+		if err := store.RemoveToken(args[0]); err != nil {
+			log.Fatalln(err)
+		}
+		addCliAudit(store, "token.revoke", args[0], "")
+		log.Println("Revoked token", args[0])
+	},
+}
+
+// addCliAudit records a change made from the command line. The change is
+// already stored, so a failure is only logged.
+func addCliAudit(store sentry_store.Store, action, target, detail string) {
+	err := store.AddAudit(sentry_store.AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Actor:     sentrylib.ActorCli,
+		Action:    action,
+		Target:    target,
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Println("Unable to write audit log:", err)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCreateCmd.Flags().StringVar(&tokenScope, "scope", "read", "read or manage")
+}
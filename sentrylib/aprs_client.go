@@ -2,81 +2,346 @@ package sentrylib
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/cenkalti/backoff"
 	"github.com/dustin/go-aprs"
 	"log"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
 type AprsClient interface {
-	Dial() error
+	Dial(ctx context.Context) error
 	Next() bool
 	Error() error
 	Frame() (aprs.Frame, error)
+	Send(frame aprs.Frame) error
+	Status() AprsStatus
 	Close() error
 }
 
+// AprsStatus reports the state of the APRS-IS connection.
+type AprsStatus struct {
+	Server         string
+	Connected      bool
+	ConnectedSince time.Time `json:",omitempty"`
+	LastPacket     time.Time `json:",omitempty"`
+	LastKeepalive  time.Time `json:",omitempty"`
+	Attempts       int
+	Reconnects     int
+	LastError      string `json:",omitempty"`
+}
+
 type aprsClient struct {
+	writeMu  sync.Mutex
 	conn     net.Conn
 	reader   *bufio.Reader
-	server   string
+	servers  []string
+	current  int
 	callsign string
 	passcode string
 	filter   string
 	err      error
 	frame    aprs.Frame
+
+	readTimeout  time.Duration
+	stallTimeout time.Duration
+	// backoff spaces out attempts across Dial calls, so a server that drops
+	// every session right away is not redialed in a tight loop
+	backoff *backoff.ExponentialBackOff
+
+	statusMu sync.Mutex
+	status   AprsStatus
 }
 
-func NewAprsClient(server, callsign, passcode, filter string) AprsClient {
+// StreamStalledError is returned by Error when the server keeps sending
+// keepalives but no packets.
+var StreamStalledError error = errors.New("APRS-IS stream stalled")
+
+// minStableSession is how long a session has to last, if it delivered no
+// packets, for the next Dial to start over without backing off.
+const minStableSession = time.Minute
+
+// NewAprsClient creates a client for servers, tried in order by Dial; it does
+// not connect by itself. A server may be a pool hostname such as rotate.aprs2.net:14580; every address it
+// resolves to is tried before moving on to the next server.
+//
+// APRS-IS servers send a keepalive every 20 seconds, so readTimeout (default
+// 1m) only expires on a dead connection. stallTimeout (default 5m) bounds how
+// long the stream may carry only keepalives. Either ends Next so the caller
+// can redial.
+func NewAprsClient(servers []string, callsign, passcode, filter string, readTimeout, stallTimeout time.Duration) AprsClient {
+	if readTimeout <= 0 {
+		readTimeout = time.Minute
+	}
+	if stallTimeout <= 0 {
+		stallTimeout = 5 * time.Minute
+	}
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = time.Second
+	policy.MaxInterval = 5 * time.Minute
+	policy.MaxElapsedTime = 0
+	policy.Reset()
 	return &aprsClient{
-		server:   server,
-		callsign: callsign,
-		passcode: passcode,
-		filter:   filter,
+		servers:      servers,
+		callsign:     callsign,
+		passcode:     passcode,
+		filter:       filter,
+		readTimeout:  readTimeout,
+		stallTimeout: stallTimeout,
+		backoff:      policy,
 	}
 }
 
-func (client *aprsClient) Dial() error {
+// Dial rotates through the configured servers until one accepts the login,
+// backing off with jitter between attempts. The backoff carries over from
+// earlier calls: after a session that delivered no packets and ended within
+// minStableSession, Dial waits before the first attempt too. It only gives
+// up when ctx is done.
+func (client *aprsClient) Dial(ctx context.Context) error {
+	if len(client.servers) == 0 {
+		return errors.New("No APRS-IS servers configured")
+	}
+	session := client.Status()
+	wasConnected := false
+	client.writeMu.Lock()
 	if client.conn != nil {
 		client.conn.Close()
+		client.conn = nil
+		wasConnected = true
 	}
-	var conn net.Conn
-	var reader *bufio.Reader
-	var err error
+	client.writeMu.Unlock()
+	client.setDisconnected(wasConnected, client.err)
+
+	if wasConnected {
+		if !session.LastPacket.IsZero() || time.Since(session.ConnectedSince) >= minStableSession {
+			client.backoff.Reset()
+		} else {
+			wait := client.backoff.NextBackOff()
+			log.Printf("Session with %s ended after %s without packets, redialing in %s\n", session.Server, time.Since(session.ConnectedSince), wait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		server := client.servers[client.current]
+		client.setAttempt(server)
+		log.Println("Dialing " + server)
+		conn, reader, err := client.connect(ctx, server)
+		if err == nil {
+			client.writeMu.Lock()
+			client.conn = conn
+			client.writeMu.Unlock()
+			// Close may have run before conn was stored
+			if ctx.Err() != nil {
+				conn.Close()
+				return ctx.Err()
+			}
+			client.reader = reader
+			client.setConnected()
+			log.Println("Connected to " + server)
+			return nil
+		}
+		client.current = (client.current + 1) % len(client.servers)
+		wait := client.backoff.NextBackOff()
+		client.setError(err)
+		log.Printf("Unable to connect to %s: %s, trying %s in %s\n", server, err, client.servers[client.current], wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// connect dials server and logs in. The login only counts once the server
+// answers with a "# logresp" line; a server that hangs up first, for example
+// because it is full, or that does not verify a passcode we sent, is a failed
+// attempt. Passcode -1 logs in read-only and is expected to be unverified.
+func (client *aprsClient) connect(ctx context.Context, server string) (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := client.login(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+func (client *aprsClient) login(conn net.Conn) (*bufio.Reader, error) {
 	connString := fmt.Sprintf("user %s pass %s filter %s\n", client.callsign, client.passcode, client.filter)
-	for i := uint(0); i < 10; i++ {
-		time.Sleep(1 << i * time.Second)
-		log.Println("Dialing " + client.server)
-		conn, err = net.Dial("tcp", client.server)
+	if _, err := conn.Write([]byte(connString)); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(client.readTimeout)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	last := ""
+	for {
+		line, err := reader.ReadString('\n')
 		if err != nil {
-			log.Println(err)
-			continue
+			if last != "" {
+				return nil, errors.New("Server closed the connection before accepting the login: " + last)
+			}
+			return nil, err
 		}
-		_, err = conn.Write([]byte(connString))
-		if err != nil {
-			log.Println(err)
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			return nil, errors.New("Server sent packets before accepting the login")
+		}
+		if !strings.HasPrefix(line, "# logresp ") {
+			// server banner
+			last = line
 			continue
 		}
-		reader = bufio.NewReader(conn)
-		break
+		fields := strings.Fields(line)
+		verified := len(fields) > 3 && strings.TrimSuffix(fields[3], ",") == "verified"
+		if !verified && client.passcode != "-1" {
+			return nil, errors.New("Server did not verify the passcode for " + client.callsign + ": " + line)
+		}
+		return reader, nil
+	}
+}
+
+func (client *aprsClient) Status() AprsStatus {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	return client.status
+}
+
+func (client *aprsClient) setAttempt(server string) {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.Server = server
+	client.status.Attempts++
+}
+
+func (client *aprsClient) setConnected() {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.Connected = true
+	client.status.ConnectedSince = time.Now()
+	client.status.LastPacket = time.Time{}
+	client.status.LastKeepalive = time.Time{}
+	client.status.Attempts = 0
+	client.status.LastError = ""
+}
+
+func (client *aprsClient) setDisconnected(reconnect bool, err error) {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.Connected = false
+	client.status.ConnectedSince = time.Time{}
+	if reconnect {
+		client.status.Reconnects++
 	}
-	if conn == nil || reader == nil {
-		return err
+	if err != nil {
+		client.status.LastError = err.Error()
 	}
-	client.conn = conn
-	client.reader = reader
-	return nil
 }
 
+func (client *aprsClient) setKeepalive(ts time.Time) {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.LastKeepalive = ts
+}
+
+func (client *aprsClient) setPacket(ts time.Time) {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.LastPacket = ts
+}
+
+func (client *aprsClient) setError(err error) {
+	client.statusMu.Lock()
+	defer client.statusMu.Unlock()
+	client.status.LastError = err.Error()
+}
+
+// Send writes a packet to APRS-IS. The server only accepts packets from
+// clients that logged in with a valid passcode.
+func (client *aprsClient) Send(frame aprs.Frame) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	if client.conn == nil {
+		return errors.New("Not connected to APRS-IS")
+	}
+	_, err := client.conn.Write([]byte(frame.String() + "\r\n"))
+	return err
+}
+
+// Close drops the current connection. A blocked Next returns false, so
+// Close can also be used from another goroutine to force a redial.
 func (client *aprsClient) Close() error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	if client.conn == nil {
+		return nil
+	}
 	return client.conn.Close()
 }
 
+// Next reads the next packet. Server comment lines starting with '#' are
+// keepalives: they extend the read deadline but are not returned as frames.
+// Next fails if nothing at all arrives within the read timeout, or if only
+// keepalives arrive for longer than the stall timeout.
 func (client *aprsClient) Next() bool {
+	for {
+		line, err := client.readLine()
+		client.err = err
+		if err != nil {
+			return false
+		}
+		now := time.Now()
+		if strings.HasPrefix(line, "#") {
+			client.setKeepalive(now)
+			status := client.Status()
+			lastPacket := status.LastPacket
+			if lastPacket.IsZero() {
+				lastPacket = status.ConnectedSince
+			}
+			if now.Sub(lastPacket) > client.stallTimeout {
+				client.err = StreamStalledError
+				return false
+			}
+			continue
+		}
+		client.setPacket(now)
+		client.frame = aprs.ParseFrame(line)
+		return true
+	}
+}
+
+func (client *aprsClient) readLine() (string, error) {
+	client.writeMu.Lock()
+	conn := client.conn
+	client.writeMu.Unlock()
+	if conn == nil {
+		return "", errors.New("Not connected to APRS-IS")
+	}
+	err := conn.SetReadDeadline(time.Now().Add(client.readTimeout))
+	if err != nil {
+		return "", err
+	}
+
 	line := ""
 	isPrefix := true
-	var err error
 	for isPrefix == true && err == nil {
 		var lineBytes []byte
 		lineBytes, isPrefix, err = client.reader.ReadLine()
@@ -84,13 +349,7 @@ func (client *aprsClient) Next() bool {
 			line = line + string(lineBytes)
 		}
 	}
-	client.err = err
-	if err != nil {
-		return false
-	}
-
-	client.frame = aprs.ParseFrame(line)
-	return true
+	return line, err
 }
 
 func (client *aprsClient) Frame() (aprs.Frame, error) {
@@ -1,76 +1,223 @@
 package sentrylib
 
-//
-//import (
-//	"testing"
-//	"github.com/docker/docker/pkg/testutil/assert"
-//	"log"
-//	"io/ioutil"
-//	"github.com/spf13/viper"
-//	"bytes"
-//)
-//
-//func TestNewAprsClient(t *testing.T) {
-//
-//	file, err := ioutil.ReadFile("../.sentry.yaml")
-//	assert.NilError(t, err)
-//
-//	viper.SetConfigType("yaml")
-//	err = viper.ReadConfig(bytes.NewBuffer(file))
-//	assert.NilError(t, err)
-//
-//	config := Config{}
-//	viper.Unmarshal(&config)
-//
-//	client := NewAprsClient(config.AprsServer, config.AprsUser, config.AprsPasscode, config.AprsFilter)
-//	err = client.Dial()
-//	defer client.Close()
-//	assert.NilError(t, err)
-//
-//	for i := 0; i < 10; i++ {
-//		if client.Next() {
-//			frame, err := client.Frame()
-//			assert.NilError(t, err)
-//			assert.NilError(t, client.Error())
-//			log.Println(frame)
-//		} else {
-//			assert.NilError(t, client.Error())
-//			break
-//		}
-//	}
-//}
-//
-//func BenchmarkNewAprsClient(b *testing.B) {
-//	b.StopTimer()
-//	file, err := ioutil.ReadFile("../.sentry.yaml")
-//	assert.NilError(b, err)
-//
-//	viper.SetConfigType("yaml")
-//	err = viper.ReadConfig(bytes.NewBuffer(file))
-//	assert.NilError(b, err)
-//
-//	config := Config{}
-//	viper.Unmarshal(&config)
-//
-//	client := NewAprsClient(config.AprsServer, config.AprsUser, config.AprsPasscode, config.AprsFilter)
-//	err = client.Dial()
-//	defer client.Close()
-//	assert.NilError(b, err)
-//
-//	valid := true
-//	b.ResetTimer()
-//	b.StartTimer()
-//	for i := 0; i < b.N; i++ {
-//		if client.Next() {
-//			frame, err := client.Frame()
-//			assert.NilError(b, err)
-//			assert.NilError(b, client.Error())
-//			log.Println(frame)
-//		} else {
-//			assert.NilError(b, client.Error())
-//			break
-//		}
-//	}
-//	b.StopTimer()
-//	log.Println(valid)
-//}
+import (
+	"bufio"
+	"context"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptLogin reads the login line from conn and accepts it the way aprsc
+// does.
+func acceptLogin(conn net.Conn) string {
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	conn.Write([]byte("# aprsc 2.1.4-g408ed49\r\n# logresp N0CALL verified, server T2TEST\r\n"))
+	return line
+}
+
+func TestAprsClient_DialFailsOver(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	deadServer := closed.Addr().String()
+	closed.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+	login := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		login <- acceptLogin(conn)
+		conn.Write([]byte("N0CALL>APRS,TCPIP*:!4903.50N/07201.75W-Test\r\n"))
+	}()
+
+	client := NewAprsClient([]string{deadServer, listener.Addr().String()}, "N0CALL", "-1", "r/49/-72/10", 0, 0)
+	err = client.Dial(context.Background())
+	assert.NilError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, <-login, "user N0CALL pass -1 filter r/49/-72/10\n")
+	assert.Equal(t, client.Next(), true)
+	frame, err := client.Frame()
+	assert.NilError(t, err)
+	assert.Equal(t, frame.Source.String(), "N0CALL")
+
+	status := client.Status()
+	assert.Equal(t, status.Connected, true)
+	assert.Equal(t, status.Server, listener.Addr().String())
+}
+
+func TestAprsClient_KeepalivesAndStall(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		acceptLogin(conn)
+		conn.Write([]byte("# keepalive\r\n"))
+		conn.Write([]byte("N0CALL>APRS,TCPIP*:!4903.50N/07201.75W-Test\r\n"))
+		for i := 0; i < 5; i++ {
+			time.Sleep(30 * time.Millisecond)
+			conn.Write([]byte("# keepalive\r\n"))
+		}
+		time.Sleep(time.Second)
+	}()
+
+	client := NewAprsClient([]string{listener.Addr().String()}, "N0CALL", "-1", "", 500*time.Millisecond, 100*time.Millisecond)
+	err = client.Dial(context.Background())
+	assert.NilError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, client.Next(), true)
+	frame, err := client.Frame()
+	assert.NilError(t, err)
+	assert.Equal(t, frame.Source.String(), "N0CALL")
+	assert.Equal(t, client.Status().LastKeepalive.IsZero(), false)
+
+	assert.Equal(t, client.Next(), false)
+	assert.Equal(t, client.Error(), StreamStalledError)
+}
+
+func TestAprsClient_ReadTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		acceptLogin(conn)
+		time.Sleep(time.Second)
+	}()
+
+	client := NewAprsClient([]string{listener.Addr().String()}, "N0CALL", "-1", "", 50*time.Millisecond, time.Minute)
+	err = client.Dial(context.Background())
+	assert.NilError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, client.Next(), false)
+	netErr, ok := client.Error().(net.Error)
+	assert.Equal(t, ok && netErr.Timeout(), true)
+}
+
+func TestAprsClient_BacksOffAfterShortSessions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+	// accepts the login, then hangs up like a full server
+	accepted := make(chan time.Time, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- time.Now()
+			acceptLogin(conn)
+			conn.Close()
+		}
+	}()
+
+	client := NewAprsClient([]string{listener.Addr().String()}, "N0CALL", "-1", "", time.Second, time.Minute)
+	policy := client.(*aprsClient).backoff
+	policy.InitialInterval = 100 * time.Millisecond
+	policy.RandomizationFactor = 0
+	policy.Reset()
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, client.Dial(context.Background()))
+		assert.Equal(t, client.Next(), false)
+	}
+	times := make([]time.Time, 0, 3)
+	for i := 0; i < 3; i++ {
+		times = append(times, <-accepted)
+	}
+	// 100ms, then 150ms with the default multiplier
+	assert.Equal(t, times[1].Sub(times[0]) >= 100*time.Millisecond, true)
+	assert.Equal(t, times[2].Sub(times[1]) >= 150*time.Millisecond, true)
+}
+
+func TestAprsClient_LoginRejected(t *testing.T) {
+	full, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer full.Close()
+	unverified, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer unverified.Close()
+	good, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer good.Close()
+
+	go func() {
+		conn, err := full.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("# Server full\r\n"))
+		conn.Close()
+	}()
+	go func() {
+		conn, err := unverified.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("# logresp N0CALL unverified, server T2BAD\r\n"))
+		time.Sleep(time.Second)
+		conn.Close()
+	}()
+	go func() {
+		conn, err := good.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		acceptLogin(conn)
+		time.Sleep(time.Second)
+	}()
+
+	client := NewAprsClient([]string{full.Addr().String(), unverified.Addr().String(), good.Addr().String()}, "N0CALL", "12345", "", time.Second, time.Minute)
+	client.(*aprsClient).backoff.InitialInterval = 10 * time.Millisecond
+	client.(*aprsClient).backoff.Reset()
+	assert.NilError(t, client.Dial(context.Background()))
+	defer client.Close()
+
+	status := client.Status()
+	assert.Equal(t, status.Server, good.Addr().String())
+	assert.Equal(t, status.Connected, true)
+}
+
+func TestAprsClient_ReadOnlyLogin(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("# logresp N0CALL unverified, server T2TEST\r\n"))
+		time.Sleep(time.Second)
+	}()
+
+	client := NewAprsClient([]string{listener.Addr().String()}, "N0CALL", "-1", "", time.Second, time.Minute)
+	assert.NilError(t, client.Dial(context.Background()))
+	defer client.Close()
+	assert.Equal(t, client.Status().Connected, true)
+}
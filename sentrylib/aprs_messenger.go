@@ -0,0 +1,189 @@
+package sentrylib
+
+import (
+	"context"
+	"github.com/dustin/go-aprs"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aprsToCall is the destination used for packets we originate. APZ is the
+// experimental tocall block.
+const aprsToCall = "APZSNT"
+
+// aprsMaxMessageLength is the longest message text allowed by the APRS spec.
+const aprsMaxMessageLength = 67
+
+// AprsMessenger notifies node owners with APRS text messages and tracks
+// acknowledgements for messages it has sent.
+type AprsMessenger interface {
+	Notifier
+	HandleFrame(frame aprs.Frame)
+}
+
+type packetSender interface {
+	Send(frame aprs.Frame) error
+}
+
+type pendingMessage struct {
+	frame    aprs.Frame
+	attempts int
+	next     time.Time
+}
+
+type aprsMessenger struct {
+	store    sentry_store.EmailAddressStore
+	sender   packetSender
+	callsign aprs.Address
+	retries  int
+	interval time.Duration
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]*pendingMessage
+}
+
+// NewAprsMessenger sends messages as config.AprsUser. Only nodes with a
+// registered owner are messaged, so we never spam every station in the
+// filter. Messages are addressed to the node's callsign without SSID, which
+// is where owners usually read messages. Unacknowledged messages are resent
+// with a growing interval.
+func NewAprsMessenger(ctx context.Context, store sentry_store.EmailAddressStore, sender packetSender, config Config) (AprsMessenger, error) {
+	retries := 3
+	interval := 30 * time.Second
+	if config.AprsMessage != nil {
+		if config.AprsMessage.Retries > 0 {
+			retries = config.AprsMessage.Retries
+		}
+		if config.AprsMessage.RetryInterval != "" {
+			var err error
+			interval, err = time.ParseDuration(config.AprsMessage.RetryInterval)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	messenger := &aprsMessenger{
+		store:    store,
+		sender:   sender,
+		callsign: aprs.AddressFromString(strings.ToUpper(config.AprsUser)),
+		retries:  retries,
+		interval: interval,
+		nextID:   1,
+		pending:  make(map[string]*pendingMessage),
+	}
+	go messenger.run(ctx)
+	return messenger, nil
+}
+
+func (messenger *aprsMessenger) Notify(ctx context.Context, notification Notification) error {
+	_, ok, err := messenger.store.GetEmail(notification.Callsign)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoRecipient
+	}
+	text := ""
+	if notification.State == StateUp {
+		text = "Sentry: " + notification.Callsign + " back online after " + (notification.Outage / time.Minute * time.Minute).String()
+	} else {
+		text = "Sentry: " + notification.Callsign + " not heard since " + notification.LastSeen.UTC().Format("2006-01-02 15:04Z")
+	}
+	recipient := aprs.Address{Call: aprs.AddressFromString(notification.Callsign).Call}
+	return messenger.send(recipient, text)
+}
+
+func (messenger *aprsMessenger) send(recipient aprs.Address, text string) error {
+	if len(text) > aprsMaxMessageLength {
+		text = text[:aprsMaxMessageLength]
+	}
+	messenger.mu.Lock()
+	id := strconv.Itoa(messenger.nextID)
+	messenger.nextID = messenger.nextID%99999 + 1
+	frame := messenger.frame(aprs.Message{Recipient: recipient, Body: text, ID: id})
+	messenger.pending[id] = &pendingMessage{
+		frame:    frame,
+		attempts: 1,
+		next:     time.Now().Add(messenger.interval),
+	}
+	messenger.mu.Unlock()
+
+	return messenger.sender.Send(frame)
+}
+
+func (messenger *aprsMessenger) frame(message aprs.Message) aprs.Frame {
+	return aprs.Frame{
+		Source: messenger.callsign,
+		Dest:   aprs.Address{Call: aprsToCall},
+		Path:   []aprs.Address{{Call: "TCPIP*"}},
+		Body:   aprs.Info(message.String()),
+	}
+}
+
+// HandleFrame looks for messages addressed to us. Acks clear the matching
+// pending message; anything else that carries an ID is acked so the sender
+// stops retrying.
+func (messenger *aprsMessenger) HandleFrame(frame aprs.Frame) {
+	message := frame.Message()
+	if !message.Parsed || !strings.EqualFold(message.Recipient.String(), messenger.callsign.String()) {
+		return
+	}
+	if message.IsACK() {
+		id := strings.TrimSpace(strings.TrimPrefix(message.Body, "ack"))
+		messenger.mu.Lock()
+		delete(messenger.pending, id)
+		messenger.mu.Unlock()
+		return
+	}
+	if message.ID != "" {
+		ack := messenger.frame(aprs.Message{Recipient: message.Sender, Body: "ack" + strings.TrimSpace(message.ID)})
+		err := messenger.sender.Send(ack)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (messenger *aprsMessenger) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(messenger.interval / 6):
+		}
+		messenger.resend(time.Now())
+	}
+}
+
+// resend retransmits pending messages that are due and gives up on those that
+// have used every retry.
+func (messenger *aprsMessenger) resend(now time.Time) {
+	due := make([]aprs.Frame, 0)
+	messenger.mu.Lock()
+	for id, pending := range messenger.pending {
+		if now.Before(pending.next) {
+			continue
+		}
+		if pending.attempts > messenger.retries {
+			log.Println("No ack for APRS message", id, pending.frame.String())
+			delete(messenger.pending, id)
+			continue
+		}
+		pending.attempts++
+		pending.next = now.Add(messenger.interval * time.Duration(pending.attempts))
+		due = append(due, pending.frame)
+	}
+	messenger.mu.Unlock()
+
+	for _, frame := range due {
+		err := messenger.sender.Send(frame)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sentrylib
+
+import (
+	"context"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/dustin/go-aprs"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	frames []aprs.Frame
+}
+
+func (sender *fakeSender) Send(frame aprs.Frame) error {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	sender.frames = append(sender.frames, frame)
+	return nil
+}
+
+func (sender *fakeSender) sent() []string {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	lines := make([]string, 0, len(sender.frames))
+	for _, frame := range sender.frames {
+		lines = append(lines, frame.String())
+	}
+	return lines
+}
+
+// fakeEmailStore registers a fixed set of callsigns.
+type fakeEmailStore struct {
+	sentry_store.EmailAddressStore
+	emails map[string]string
+}
+
+func (store fakeEmailStore) GetEmail(callsign string) (string, bool, error) {
+	email, ok := store.emails[callsign]
+	return email, ok, nil
+}
+
+func newTestMessenger(t *testing.T, sender *fakeSender) *aprsMessenger {
+	store := fakeEmailStore{emails: map[string]string{"N0CALL-10": "owner@example.com"}}
+	messenger, err := NewAprsMessenger(context.Background(), store, sender, Config{
+		AprsUser:    "sentry",
+		AprsMessage: &AprsMessageConfig{Retries: 2, RetryInterval: "1h"},
+	})
+	assert.NilError(t, err)
+	return messenger.(*aprsMessenger)
+}
+
+func TestAprsMessenger_Notify(t *testing.T) {
+	sender := &fakeSender{}
+	messenger := newTestMessenger(t, sender)
+
+	err := messenger.Notify(context.Background(), Notification{
+		Callsign: "N0CALL-10",
+		State:    StateDown,
+		LastSeen: time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC),
+	})
+	assert.NilError(t, err)
+	err = messenger.Notify(context.Background(), Notification{Callsign: "N0CALL-11", State: StateDown, LastSeen: time.Now()})
+	assert.Equal(t, err, ErrNoRecipient)
+
+	assert.DeepEqual(t, sender.sent(), []string{
+		"SENTRY>APZSNT,TCPIP*::N0CALL   :Sentry: N0CALL-10 not heard since 2017-06-01 12:30Z{1",
+	})
+}
+
+func TestAprsMessenger_AckStopsRetries(t *testing.T) {
+	sender := &fakeSender{}
+	messenger := newTestMessenger(t, sender)
+
+	err := messenger.Notify(context.Background(), Notification{Callsign: "N0CALL-10", State: StateUp, Outage: 90 * time.Minute})
+	assert.NilError(t, err)
+	err = messenger.Notify(context.Background(), Notification{Callsign: "N0CALL-10", State: StateDown, LastSeen: time.Now()})
+	assert.NilError(t, err)
+	assert.Equal(t, sender.sent()[0], "SENTRY>APZSNT,TCPIP*::N0CALL   :Sentry: N0CALL-10 back online after 1h30m0s{1")
+
+	messenger.HandleFrame(aprs.ParseFrame("N0CALL-7>APDR15,TCPIP*,qAC,T2TEXAS::SENTRY   :ack1"))
+
+	messenger.resend(time.Now().Add(time.Hour))
+	sent := sender.sent()
+	assert.Equal(t, len(sent), 3)
+	assert.Equal(t, sent[2], sent[1])
+
+	// one more retry, then the unacked message is dropped
+	messenger.resend(time.Now().Add(3 * time.Hour))
+	messenger.resend(time.Now().Add(6 * time.Hour))
+	assert.Equal(t, len(sender.sent()), 4)
+	assert.Equal(t, len(messenger.pending), 0)
+}
+
+func TestAprsMessenger_AcksIncomingMessages(t *testing.T) {
+	sender := &fakeSender{}
+	messenger := newTestMessenger(t, sender)
+
+	messenger.HandleFrame(aprs.ParseFrame("N0CALL-7>APDR15,TCPIP*,qAC,T2TEXAS::SENTRY   :thanks{42"))
+	messenger.HandleFrame(aprs.ParseFrame("N0CALL-7>APDR15,TCPIP*,qAC,T2TEXAS::OTHER    :hello{43"))
+
+	assert.DeepEqual(t, sender.sent(), []string{
+		"SENTRY>APZSNT,TCPIP*::N0CALL-7 :ack42",
+	})
+}
@@ -0,0 +1,301 @@
+package sentrylib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/gorilla/mux"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Actors recorded in the audit log for changes that were not made with an
+// API token.
+const (
+	ActorLocal       = "local"
+	ActorCli         = "cli"
+	ActorSelfService = "self-service"
+)
+
+// tokenPrefix starts every token secret so leaked secrets are easy to
+// recognize.
+const tokenPrefix = "sentry_"
+
+var tokenNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// NewApiToken creates a token named name with a random secret. The secret is
+// returned separately and is not stored anywhere, so it has to be shown to the
+// user right away.
+func NewApiToken(name string, scope sentry_store.TokenScope) (sentry_store.ApiToken, string, error) {
+	if !tokenNamePattern.MatchString(name) {
+		return sentry_store.ApiToken{}, "", errors.New("Token names must be 1 to 64 letters, digits, '.', '_' or '-'")
+	}
+	if _, err := ParseTokenScope(string(scope)); err != nil {
+		return sentry_store.ApiToken{}, "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return sentry_store.ApiToken{}, "", err
+	}
+	encoded := tokenPrefix + hex.EncodeToString(secret)
+	token := sentry_store.ApiToken{
+		Name:    name,
+		Hash:    hashToken(encoded),
+		Scope:   scope,
+		Created: time.Now().UTC(),
+	}
+	return token, encoded, nil
+}
+
+// ParseTokenScope accepts "read" or "manage".
+func ParseTokenScope(scope string) (sentry_store.TokenScope, error) {
+	switch sentry_store.TokenScope(scope) {
+	case sentry_store.ScopeRead, sentry_store.ScopeManage:
+		return sentry_store.TokenScope(scope), nil
+	}
+	return "", errors.New("Unknown token scope '" + scope + "', expected read or manage")
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// allows reports whether a token with scope may do what required needs.
+// Manage includes read.
+func allows(scope, required sentry_store.TokenScope) bool {
+	return scope == sentry_store.ScopeManage || scope == required
+}
+
+type actorKey struct{}
+
+// requestActor is the name the admin API audit log records for r.
+func requestActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return ActorLocal
+}
+
+// adminAuth guards the admin API. Requests need an "Authorization: Bearer"
+// header with a token from the store: GET and HEAD need the read scope,
+// anything else manage. Unless requireToken is set, requests without a token
+// are still let through when they come from a loopback address or a Unix
+// socket and were not forwarded by a proxy, so existing local tooling keeps
+// working.
+type adminAuth struct {
+	store        sentry_store.Store
+	requireToken bool
+	handler      http.Handler
+}
+
+func (auth adminAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	required := sentry_store.ScopeManage
+	if r.Method == "GET" || r.Method == "HEAD" {
+		required = sentry_store.ScopeRead
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		if !auth.requireToken && isLocalRequest(r) {
+			auth.handler.ServeHTTP(w, r)
+			return
+		}
+		unauthorized(w, "", "unauthorized", "An API token is required")
+		return
+	}
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		unauthorized(w, "invalid_request", "invalid_request", "Expected an 'Authorization: Bearer' header")
+		return
+	}
+
+	token, ok, err := auth.lookup(strings.TrimSpace(header[7:]))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if !ok {
+		unauthorized(w, "invalid_token", "invalid_token", "Unknown or revoked API token")
+		return
+	}
+	if !allows(token.Scope, required) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sentry", error="insufficient_scope", scope="`+string(required)+`"`)
+		writeError(w, http.StatusForbidden, "insufficient_scope", "Token '"+token.Name+"' does not have the "+string(required)+" scope")
+		return
+	}
+	ctx := context.WithValue(r.Context(), actorKey{}, token.Name)
+	auth.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// lookup finds the token whose hash matches secret. Every stored hash is
+// compared in constant time so response timing does not reveal how close a
+// guess was.
+func (auth adminAuth) lookup(secret string) (sentry_store.ApiToken, bool, error) {
+	tokens, err := auth.store.ListTokens()
+	if err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	hash := []byte(hashToken(secret))
+	found := -1
+	for i, token := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return sentry_store.ApiToken{}, false, nil
+	}
+	return tokens[found], true, nil
+}
+
+// unauthorized sends a 401 with a Bearer challenge. challengeError is the
+// RFC 6750 error code for the challenge and may be empty.
+func unauthorized(w http.ResponseWriter, challengeError, code, message string) {
+	challenge := `Bearer realm="sentry"`
+	if challengeError != "" {
+		challenge += `, error="` + challengeError + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, code, message)
+}
+
+// isLocalRequest reports whether r came straight from this machine. Requests
+// on a Unix socket have no remote address. Anything carrying proxy headers is
+// treated as remote, since a local reverse proxy makes every client look
+// local.
+func isLocalRequest(r *http.Request) bool {
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// audit records a change in the audit log. The change itself already
+// happened, so failing to record it is logged rather than reported to the
+// client.
+func (s webServer) audit(actor, action, target, detail string) {
+	err := s.store.AddAudit(sentry_store.AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Println("Unable to write audit log:", err)
+	}
+}
+
+func (s webServer) listAudit(w http.ResponseWriter, r *http.Request) {
+	since := time.Time{}
+	if param := r.URL.Query().Get("since"); param != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_since", "Unable to parse since, expected RFC3339: "+err.Error())
+			return
+		}
+	}
+	entries, err := s.store.ListAudit(since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// apiTokenInfo is how the admin API shows a token. The hash is left out.
+type apiTokenInfo struct {
+	Name    string
+	Scope   sentry_store.TokenScope
+	Created time.Time
+	// Secret is only set in the response that created the token.
+	Secret string `json:",omitempty"`
+}
+
+type createTokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+func (s webServer) listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.store.ListTokens()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	infos := make([]apiTokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		infos = append(infos, apiTokenInfo{Name: token.Name, Scope: token.Scope, Created: token.Created})
+	}
+	writeJSON(w, infos)
+}
+
+// createToken takes {"name": "...", "scope": "read"} and answers with the
+// new token's secret. Like "sentry token create" it refuses to replace an
+// existing token.
+func (s webServer) createToken(w http.ResponseWriter, r *http.Request) {
+	req := createTokenRequest{Scope: string(sentry_store.ScopeRead)}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Unable to parse JSON body: "+err.Error())
+		return
+	}
+	scope, err := ParseTokenScope(req.Scope)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+	token, secret, err := NewApiToken(req.Name, scope)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_name", err.Error())
+		return
+	}
+	if _, ok, err := s.store.GetToken(token.Name); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	} else if ok {
+		writeError(w, http.StatusConflict, "token_exists", "Token '"+token.Name+"' already exists, revoke it first")
+		return
+	}
+	if err := s.store.AddToken(token); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	s.audit(requestActor(r), "token.create", token.Name, string(token.Scope))
+	writeJSONStatus(w, http.StatusCreated, apiTokenInfo{Name: token.Name, Scope: token.Scope, Created: token.Created, Secret: secret})
+}
+
+func (s webServer) revokeToken(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok, err := s.store.GetToken(name); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	} else if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No token named '"+name+"'")
+		return
+	}
+	if err := s.store.RemoveToken(name); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	s.audit(requestActor(r), "token.revoke", name, "")
+	w.WriteHeader(http.StatusNoContent)
+}
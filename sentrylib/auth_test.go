@@ -0,0 +1,198 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin server. secret is sent as a
+// bearer token unless empty; remote is the client address.
+func adminRequest(ws webServer, method, path, secret, remote string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.RemoteAddr = remote
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	ws.admin.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func addTestToken(t *testing.T, store sentry_store.Store, name string, scope sentry_store.TokenScope) string {
+	token, secret, err := NewApiToken(name, scope)
+	assert.NilError(t, err)
+	assert.NilError(t, store.AddToken(token))
+	return secret
+}
+
+func TestNewApiToken(t *testing.T) {
+	token, secret, err := NewApiToken("club-portal", sentry_store.ScopeManage)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(secret, "sentry_"), true)
+	assert.Equal(t, token.Hash, hashToken(secret))
+	assert.Equal(t, strings.Contains(token.Hash, secret), false)
+
+	_, _, err = NewApiToken("club portal", sentry_store.ScopeRead)
+	assert.Error(t, err, "Token names")
+	_, _, err = NewApiToken("portal", "admin")
+	assert.Error(t, err, "Unknown token scope 'admin'")
+}
+
+func TestAdminAuth_Scopes(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	reader := addTestToken(t, store, "monitor", sentry_store.ScopeRead)
+	manager := addTestToken(t, store, "portal", sentry_store.ScopeManage)
+	remote := "192.0.2.1:1234"
+
+	rec := adminRequest(ws, "GET", "/email", "", remote, nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+	assert.Equal(t, rec.Header().Get("WWW-Authenticate"), `Bearer realm="sentry"`)
+
+	rec = adminRequest(ws, "GET", "/email", "sentry_bogus", remote, nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	rec = adminRequest(ws, "GET", "/email", reader, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	rec = adminRequest(ws, "PUT", "/email/N0CALL-1", reader, remote, strings.NewReader("owner@example.com"))
+	assert.Equal(t, rec.Code, http.StatusForbidden)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+	_, ok, err := store.GetEmail("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	rec = adminRequest(ws, "PUT", "/email/N0CALL-1", manager, remote, strings.NewReader("owner@example.com"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	_, ok, err = store.GetEmail("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	// revoked tokens stop working right away
+	assert.NilError(t, store.RemoveToken("portal"))
+	rec = adminRequest(ws, "DELETE", "/email/N0CALL-1", manager, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestAdminAuth_Local(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+
+	rec := adminRequest(ws, "PUT", "/cutoff/N0CALL-1", "", "127.0.0.1:5555", strings.NewReader("6h"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	rec = adminRequest(ws, "GET", "/email", "", "[::1]:5555", nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	rec = adminRequest(ws, "GET", "/email", "", "", nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	// a bad token is rejected even from localhost
+	rec = adminRequest(ws, "GET", "/email", "sentry_bogus", "127.0.0.1:5555", nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+
+	// a local reverse proxy does not make its clients local
+	req := httptest.NewRequest("GET", "/email", nil)
+	req.RemoteAddr = "127.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	rec = httptest.NewRecorder()
+	ws.admin.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+
+	ws2, err := NewWebServer(Config{Web: &WebConfig{RequireAdminToken: true}}, store, nil, nil)
+	assert.NilError(t, err)
+	rec = adminRequest(ws2.(webServer), "GET", "/email", "", "127.0.0.1:5555", nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestAdminAudit(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	manager := addTestToken(t, store, "portal", sentry_store.ScopeManage)
+	reader := addTestToken(t, store, "monitor", sentry_store.ScopeRead)
+	remote := "192.0.2.1:1234"
+
+	adminRequest(ws, "PUT", "/email/N0CALL-1", manager, remote, strings.NewReader("owner@example.com"))
+	adminRequest(ws, "PUT", "/cutoff/N0CALL-1", "", "127.0.0.1:5555", strings.NewReader("6h"))
+	adminRequest(ws, "DELETE", "/email/N0CALL-1", manager, remote, nil)
+
+	rec := adminRequest(ws, "GET", "/audit", reader, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	entries := []sentry_store.AuditEntry{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(t, len(entries), 3)
+	assert.Equal(t, entries[0].Actor, "portal")
+	assert.Equal(t, entries[0].Action, "email.add")
+	assert.Equal(t, entries[0].Target, "N0CALL-1")
+	assert.Equal(t, entries[0].Detail, "owner@example.com")
+	assert.Equal(t, entries[1].Actor, "local")
+	assert.Equal(t, entries[1].Action, "cutoff.add")
+	assert.Equal(t, entries[1].Detail, "6h0m0s")
+	assert.Equal(t, entries[2].Action, "email.remove")
+
+	rec = adminRequest(ws, "GET", "/audit?since=yesterday", reader, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+	rec = adminRequest(ws, "GET", "/audit?since=2999-01-01T00:00:00Z", reader, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(t, len(entries), 0)
+}
+
+func TestAdminTokens(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	manager := addTestToken(t, store, "portal", sentry_store.ScopeManage)
+	reader := addTestToken(t, store, "monitor", sentry_store.ScopeRead)
+	remote := "192.0.2.1:1234"
+
+	rec := adminRequest(ws, "POST", "/tokens", reader, remote, strings.NewReader(`{"name": "ci", "scope": "read"}`))
+	assert.Equal(t, rec.Code, http.StatusForbidden)
+
+	rec = adminRequest(ws, "POST", "/tokens", manager, remote, strings.NewReader(`{"name": "ci", "scope": "read"}`))
+	assert.Equal(t, rec.Code, http.StatusCreated)
+	assert.Equal(t, rec.Result().Header.Get("Content-Type"), "application/json")
+	created := apiTokenInfo{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, created.Name, "ci")
+	assert.Equal(t, created.Scope, sentry_store.ScopeRead)
+	assert.Equal(t, strings.HasPrefix(created.Secret, "sentry_"), true)
+
+	// the new secret works right away
+	rec = adminRequest(ws, "GET", "/tokens", created.Secret, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, strings.Contains(rec.Body.String(), "Hash"), false)
+	assert.Equal(t, strings.Contains(rec.Body.String(), "Secret"), false)
+	tokens := []apiTokenInfo{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	assert.Equal(t, len(tokens), 3)
+
+	rec = adminRequest(ws, "POST", "/tokens", manager, remote, strings.NewReader(`{"name": "ci"}`))
+	assert.Equal(t, rec.Code, http.StatusConflict)
+	rec = adminRequest(ws, "POST", "/tokens", manager, remote, strings.NewReader(`{"name": "ci 2"}`))
+	assert.Equal(t, decodeError(t, rec).Code, "invalid_name")
+	rec = adminRequest(ws, "POST", "/tokens", manager, remote, strings.NewReader(`{"name": "ci2", "scope": "admin"}`))
+	assert.Equal(t, decodeError(t, rec).Code, "invalid_scope")
+
+	rec = adminRequest(ws, "DELETE", "/tokens/ci", manager, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusNoContent)
+	rec = adminRequest(ws, "GET", "/tokens", created.Secret, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+	rec = adminRequest(ws, "DELETE", "/tokens/ci", manager, remote, nil)
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+
+	entries, err := store.ListAudit(time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].Action, "token.create")
+	assert.Equal(t, entries[0].Actor, "portal")
+	assert.Equal(t, entries[0].Detail, "read")
+	assert.Equal(t, entries[1].Action, "token.revoke")
+	assert.Equal(t, entries[1].Target, "ci")
+}
@@ -0,0 +1,86 @@
+package sentrylib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Intervals shorter than this are almost always the same packet arriving via
+// a second igate, not a new beacon.
+const minBeaconInterval = 5 * time.Second
+
+// cadenceWindow is the number of recent intervals kept per callsign.
+const cadenceWindow = 20
+
+// AdaptiveCutoff learns how often each callsign beacons and derives a
+// per-callsign cutoff from it.
+type AdaptiveCutoff struct {
+	mu         sync.Mutex
+	intervals  map[string][]time.Duration
+	factor     float64
+	minSamples int
+	floor      time.Duration
+	ceiling    time.Duration
+}
+
+func NewAdaptiveCutoff(factor float64, minSamples int, floor, ceiling time.Duration) *AdaptiveCutoff {
+	return &AdaptiveCutoff{
+		intervals:  make(map[string][]time.Duration),
+		factor:     factor,
+		minSamples: minSamples,
+		floor:      floor,
+		ceiling:    ceiling,
+	}
+}
+
+// Observe records the time between two consecutive packets from callsign.
+func (adaptive *AdaptiveCutoff) Observe(callsign string, interval time.Duration) {
+	if interval < minBeaconInterval {
+		return
+	}
+	adaptive.mu.Lock()
+	defer adaptive.mu.Unlock()
+	samples := append(adaptive.intervals[callsign], interval)
+	if len(samples) > cadenceWindow {
+		samples = samples[len(samples)-cadenceWindow:]
+	}
+	adaptive.intervals[callsign] = samples
+}
+
+// Cutoff returns the learned cutoff for callsign, or false if not enough
+// intervals have been observed yet.
+func (adaptive *AdaptiveCutoff) Cutoff(callsign string) (time.Duration, bool) {
+	adaptive.mu.Lock()
+	samples := append([]time.Duration(nil), adaptive.intervals[callsign]...)
+	adaptive.mu.Unlock()
+
+	if len(samples) == 0 || len(samples) < adaptive.minSamples {
+		return 0, false
+	}
+	sort.Sort(durations(samples))
+	median := samples[len(samples)/2]
+	if len(samples)%2 == 0 {
+		median = (samples[len(samples)/2-1] + median) / 2
+	}
+
+	cutoff := time.Duration(float64(median) * adaptive.factor)
+	if cutoff < adaptive.floor {
+		cutoff = adaptive.floor
+	}
+	if cutoff > adaptive.ceiling {
+		cutoff = adaptive.ceiling
+	}
+	return cutoff, true
+}
+
+// Floor is the shortest cutoff Cutoff can return.
+func (adaptive *AdaptiveCutoff) Floor() time.Duration {
+	return adaptive.floor
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
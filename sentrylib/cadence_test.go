@@ -0,0 +1,44 @@
+package sentrylib
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveCutoff_NotEnoughSamples(t *testing.T) {
+	adaptive := NewAdaptiveCutoff(3, 3, time.Hour, 25*time.Hour)
+	adaptive.Observe("FOO", 10*time.Minute)
+	adaptive.Observe("FOO", 10*time.Minute)
+	_, ok := adaptive.Cutoff("FOO")
+	assert.Equal(t, ok, false)
+	_, ok = adaptive.Cutoff("BAR")
+	assert.Equal(t, ok, false)
+}
+
+func TestAdaptiveCutoff_Median(t *testing.T) {
+	adaptive := NewAdaptiveCutoff(3, 3, time.Minute, 25*time.Hour)
+	adaptive.Observe("FOO", 10*time.Minute)
+	adaptive.Observe("FOO", 12*time.Minute)
+	adaptive.Observe("FOO", 5*time.Hour)
+	cutoff, ok := adaptive.Cutoff("FOO")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, cutoff, 36*time.Minute)
+}
+
+func TestAdaptiveCutoff_Clamp(t *testing.T) {
+	adaptive := NewAdaptiveCutoff(3, 1, time.Hour, 12*time.Hour)
+	adaptive.Observe("FAST", 10*time.Minute)
+	adaptive.Observe("SLOW", 6*time.Hour)
+	cutoff, _ := adaptive.Cutoff("FAST")
+	assert.Equal(t, cutoff, time.Hour)
+	cutoff, _ = adaptive.Cutoff("SLOW")
+	assert.Equal(t, cutoff, 12*time.Hour)
+}
+
+func TestAdaptiveCutoff_IgnoresDuplicates(t *testing.T) {
+	adaptive := NewAdaptiveCutoff(3, 1, time.Minute, 25*time.Hour)
+	adaptive.Observe("FOO", time.Second)
+	_, ok := adaptive.Cutoff("FOO")
+	assert.Equal(t, ok, false)
+}
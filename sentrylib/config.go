@@ -1,17 +1,40 @@
 package sentrylib
 
+// Config is loaded from sentry.json/yaml. AprsServers lists APRS-IS servers
+// to fail over between; AprsServer, if set, is tried first.
 type Config struct {
-	AprsServer      string
-	AprsUser        string
-	AprsPasscode    string
-	AprsFilter      string
-	Cutoff          string
-	SkipCooldown    bool             `json:",omitempty"`
-	Mailgun         *MailgunConfig   `json:",omitempty"`
-	BoltConfig      *BoltConfig      `json:",omitempty"`
-	PostgresConfig  *PostgresConfig  `json:",omitempty"`
-	GoLevelDBConfig *GoLevelDbConfig `json:",omitempty"`
-	RethinkDBConfig *RethinkConfig   `json:",omitempty"`
+	AprsServer       string
+	AprsServers      []string `json:",omitempty"`
+	AprsUser         string
+	AprsPasscode     string
+	AprsFilter       string
+	AprsReadTimeout  string `json:",omitempty"`
+	AprsStallTimeout string `json:",omitempty"`
+	Cutoff           string
+	SkipCooldown     bool                `json:",omitempty"`
+	Adaptive         *AdaptiveConfig     `json:",omitempty"`
+	Mailgun          *MailgunConfig      `json:",omitempty"`
+	Smtp             *SmtpConfig         `json:",omitempty"`
+	Webhook          *WebhookConfig      `json:",omitempty"`
+	Subscription     *SubscriptionConfig `json:",omitempty"`
+	Web              *WebConfig          `json:",omitempty"`
+	AprsMessage      *AprsMessageConfig  `json:",omitempty"`
+	BoltConfig       *BoltConfig         `json:",omitempty"`
+	PostgresConfig   *PostgresConfig     `json:",omitempty"`
+	GoLevelDBConfig  *GoLevelDbConfig    `json:",omitempty"`
+	RethinkDBConfig  *RethinkConfig      `json:",omitempty"`
+	SqliteConfig     *SqliteConfig       `json:",omitempty"`
+}
+
+// AdaptiveConfig enables down-detection based on each node's own beacon
+// interval. A node is reaped after missing MissedBeacons median intervals,
+// clamped to [Floor, Ceiling]. Nodes with fewer than MinSamples observed
+// intervals fall back to Cutoff.
+type AdaptiveConfig struct {
+	MissedBeacons float64
+	MinSamples    int
+	Floor         string
+	Ceiling       string
 }
 
 type MailgunConfig struct {
@@ -21,6 +44,71 @@ type MailgunConfig struct {
 	FromAddress string
 }
 
+// SmtpConfig sends mail through a plain SMTP server. Security is one of
+// "starttls" (default), "tls" for implicit TLS, or "none". Port defaults to
+// 587, or 465 for implicit TLS.
+type SmtpConfig struct {
+	Host        string
+	Port        int    `json:",omitempty"`
+	Username    string `json:",omitempty"`
+	Password    string `json:",omitempty"`
+	FromAddress string
+	Security    string `json:",omitempty"`
+}
+
+// WebhookConfig POSTs a JSON payload to every URL when a node goes down or
+// comes back. If Secret is set each request carries an HMAC-SHA256 signature
+// of the body. Failed deliveries are retried Retries times with backoff,
+// 3 by default; a negative value disables retries.
+type WebhookConfig struct {
+	Urls    []string
+	Secret  string `json:",omitempty"`
+	Retries int    `json:",omitempty"`
+	Timeout string `json:",omitempty"`
+}
+
+// WebConfig configures the public server (API, dashboard, metrics, default
+// :8080) and the admin server (email and cutoff management, default
+// 127.0.0.1:8081). The admin server accepts API tokens created with "sentry
+// token create" or POST /tokens; requests without one are only allowed from loopback
+// addresses and Unix sockets, and not at all if RequireAdminToken is set.
+type WebConfig struct {
+	Public            *ListenerConfig `json:",omitempty"`
+	Admin             *ListenerConfig `json:",omitempty"`
+	RequireAdminToken bool            `json:",omitempty"`
+}
+
+// ListenerConfig sets where an HTTP server listens. Address is host:port, or
+// "unix:/path/to/socket" for a Unix socket created with mode 0660. HTTPS is
+// served when TlsCert and TlsKey name PEM files. Timeouts default to 10s for
+// reads, 30s for writes and 2m for idle keep-alive connections.
+type ListenerConfig struct {
+	Address      string `json:",omitempty"`
+	TlsCert      string `json:",omitempty"`
+	TlsKey       string `json:",omitempty"`
+	ReadTimeout  string `json:",omitempty"`
+	WriteTimeout string `json:",omitempty"`
+	IdleTimeout  string `json:",omitempty"`
+}
+
+// SubscriptionConfig lets owners subscribe and unsubscribe themselves on the
+// public web server. Links in mails point at PublicUrl, e.g.
+// "https://sentry.example.org", and are signed with Secret. Confirmation links
+// expire after ConfirmTimeout, 24h by default. Requires a mail backend.
+type SubscriptionConfig struct {
+	PublicUrl      string
+	Secret         string
+	ConfirmTimeout string `json:",omitempty"`
+}
+
+// AprsMessageConfig enables APRS text message notifications sent as AprsUser
+// over the APRS-IS connection. Messages that are not acked are resent up to
+// Retries times, waiting RetryInterval longer after each attempt.
+type AprsMessageConfig struct {
+	Retries       int    `json:",omitempty"`
+	RetryInterval string `json:",omitempty"`
+}
+
 type BoltConfig struct {
 	File string
 }
@@ -31,6 +119,9 @@ type PostgresConfig struct {
 	Host       string
 	DbName     string
 	SslMode    string
+	// DisableAutoMigrate refuses to start on an outdated schema instead of
+	// migrating it; run "sentry db migrate" to upgrade.
+	DisableAutoMigrate bool `json:",omitempty"`
 }
 type RethinkConfig struct {
 	Address  string
@@ -42,3 +133,58 @@ type RethinkConfig struct {
 type GoLevelDbConfig struct {
 	File string
 }
+
+// SqliteConfig stores everything in File. The SQLite driver needs a cgo
+// build, see package sentry_sqlite.
+type SqliteConfig struct {
+	File string
+}
+
+// redacted is the placeholder Redacted puts in place of secrets.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of config that is safe to log, with passwords, API
+// keys and signing secrets replaced.
+func (config Config) Redacted() Config {
+	mask := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return redacted
+	}
+	config.AprsPasscode = mask(config.AprsPasscode)
+	if config.Mailgun != nil {
+		mailgun := *config.Mailgun
+		mailgun.ApiKey = mask(mailgun.ApiKey)
+		mailgun.PubApiKey = mask(mailgun.PubApiKey)
+		config.Mailgun = &mailgun
+	}
+	if config.Smtp != nil {
+		smtp := *config.Smtp
+		smtp.Password = mask(smtp.Password)
+		config.Smtp = &smtp
+	}
+	if config.Webhook != nil {
+		webhook := *config.Webhook
+		webhook.Secret = mask(webhook.Secret)
+		config.Webhook = &webhook
+	}
+	if config.Subscription != nil {
+		subscription := *config.Subscription
+		subscription.Secret = mask(subscription.Secret)
+		config.Subscription = &subscription
+	}
+	if config.PostgresConfig != nil {
+		postgres := *config.PostgresConfig
+		// connection strings may carry the password inline
+		postgres.ConnString = mask(postgres.ConnString)
+		postgres.Password = mask(postgres.Password)
+		config.PostgresConfig = &postgres
+	}
+	if config.RethinkDBConfig != nil {
+		rethink := *config.RethinkDBConfig
+		rethink.Password = mask(rethink.Password)
+		config.RethinkDBConfig = &rethink
+	}
+	return config
+}
@@ -0,0 +1,542 @@
+package sentrylib
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/gorilla/mux"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The dashboard is a few server rendered pages for people who would rather
+// not read JSON. Sorting and filtering happen in the browser; the map pulls
+// /api/live.geojson and /api/dead.geojson.
+
+type dashboardRow struct {
+	Callsign string
+	State    string
+	LastSeen time.Time
+	Node     *sentry_store.NodeInfo
+}
+
+type dashboardPage struct {
+	Title     string
+	Now       time.Time
+	Live      int
+	Dead      int
+	Rows      []dashboardRow
+	Subscribe bool
+}
+
+type nodePage struct {
+	Title  string
+	Now    time.Time
+	Row    dashboardRow
+	Events []sentry_store.CallsignEvent
+}
+
+type subscribePage struct {
+	Title    string
+	Now      time.Time
+	Callsign string
+	Email    string
+	Error    string
+}
+
+type confirmPage struct {
+	Title   string
+	Now     time.Time
+	Message string
+	Action  string
+	Token   string
+	Button  string
+}
+
+type messagePage struct {
+	Title   string
+	Now     time.Time
+	Message string
+}
+
+var dashboardFuncs = template.FuncMap{
+	"ago":       ago,
+	"duration":  roundDuration,
+	"timestamp": func(ts time.Time) string { return ts.UTC().Format(time.RFC3339) },
+	"unix":      func(ts time.Time) int64 { return ts.Unix() },
+}
+
+var dashboardTemplates = parseDashboardTemplates(map[string]string{
+	"index":     indexTemplate,
+	"node":      nodeTemplate,
+	"map":       mapTemplate,
+	"subscribe": subscribeTemplate,
+	"confirm":   confirmTemplate,
+	"message":   messageTemplate,
+})
+
+// parseDashboardTemplates parses each page together with the shared header
+// and footer.
+func parseDashboardTemplates(pages map[string]string) *template.Template {
+	templates := template.Must(template.New("layout").Funcs(dashboardFuncs).Parse(layoutTemplate))
+	for name, text := range pages {
+		template.Must(templates.New(name).Parse(text))
+	}
+	return templates
+}
+
+// ago renders the time between ts and now the way people read it on a
+// status page, e.g. "3m ago".
+func ago(now, ts time.Time) string {
+	return roundDuration(now.Sub(ts)) + " ago"
+}
+
+// roundDuration drops everything below a second, or below a minute once the
+// duration passes an hour.
+func roundDuration(d time.Duration) string {
+	unit := time.Second
+	if d >= time.Hour {
+		unit = time.Minute
+	}
+	return (d / unit * unit).String()
+}
+
+func (s webServer) dashboardRows() ([]dashboardRow, error) {
+	live, err := s.store.ListLive(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	dead, err := s.store.ListDead()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]dashboardRow, 0, len(live)+len(dead))
+	for _, entry := range live {
+		rows = append(rows, dashboardRow{Callsign: entry.Callsign, State: StateUp, LastSeen: entry.LastSeen})
+	}
+	for _, entry := range dead {
+		rows = append(rows, dashboardRow{Callsign: entry.Callsign, State: StateDown, LastSeen: entry.LastSeen})
+	}
+	// one ListNodes instead of a lookup per row
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]*sentry_store.NodeInfo, len(nodes))
+	for i := range nodes {
+		infos[nodes[i].Callsign] = &nodes[i]
+	}
+	for i := range rows {
+		rows[i].Node = infos[rows[i].Callsign]
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Callsign < rows[j].Callsign
+	})
+	return rows, nil
+}
+
+func (s webServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.dashboardRows()
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	page := dashboardPage{Title: "Nodes", Now: time.Now(), Rows: rows, Subscribe: s.subscriptions != nil}
+	for _, row := range rows {
+		if row.State == StateUp {
+			page.Live++
+		} else {
+			page.Dead++
+		}
+	}
+	renderDashboard(w, 200, "index", page)
+}
+
+func (s webServer) dashboardNode(w http.ResponseWriter, r *http.Request) {
+	callsign := mux.Vars(r)["node"]
+	row := dashboardRow{Callsign: callsign, State: StateUp}
+	ts, ok, err := s.store.GetLive(callsign)
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !ok {
+		row.State = StateDown
+		ts, ok, err = s.store.GetDead(callsign)
+		if err != nil {
+			w.WriteHeader(501)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if !ok {
+		w.WriteHeader(404)
+		w.Write([]byte("Could not find node with callsign '" + callsign + "'"))
+		return
+	}
+	row.LastSeen = ts
+	node, ok, err := s.store.GetNode(callsign)
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if ok {
+		row.Node = &node
+	}
+	events, err := s.store.ListEvents(callsign, time.Time{})
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	renderDashboard(w, 200, "node", nodePage{Title: callsign, Now: time.Now(), Row: row, Events: events})
+}
+
+func (s webServer) dashboardMap(w http.ResponseWriter, r *http.Request) {
+	renderDashboard(w, 200, "map", dashboardPage{Title: "Map", Now: time.Now()})
+}
+
+func (s webServer) subscribeForm(w http.ResponseWriter, r *http.Request) {
+	renderDashboard(w, 200, "subscribe", subscribePage{Title: "Email alerts", Now: time.Now(), Callsign: r.URL.Query().Get("callsign")})
+}
+
+// Limits on confirmation mails sent by /subscribe per hour. Clients are told
+// apart by their remote address, so everyone behind a reverse proxy shares
+// the per-IP limit.
+const (
+	subscribeLimitPerIP      = 10
+	subscribeLimitPerAddress = 3
+)
+
+// subscribe mails a confirmation link. Nothing is stored until the link is
+// followed.
+func (s webServer) subscribe(w http.ResponseWriter, r *http.Request) {
+	page := subscribePage{
+		Title:    "Email alerts",
+		Now:      time.Now(),
+		Callsign: strings.ToUpper(strings.TrimSpace(r.FormValue("callsign"))),
+		Email:    strings.TrimSpace(r.FormValue("email")),
+	}
+	if callsign, err := normalizeCallsign(page.Callsign); err != nil {
+		page.Error = "Please enter a callsign such as N0CALL-10."
+	} else if email, err := normalizeAddress(page.Email); err != nil {
+		page.Error = "Please enter a valid email address."
+	} else {
+		page.Callsign = callsign
+		page.Email = email
+	}
+	if page.Error != "" {
+		renderDashboard(w, 400, "subscribe", page)
+		return
+	}
+	if !s.subscribeByIP.Allow(clientIP(r), page.Now) || !s.subscribeByAddress.Allow(strings.ToLower(page.Email), page.Now) {
+		w.Header().Set("Retry-After", "3600")
+		renderDashboard(w, 429, "message", messagePage{"Email alerts", time.Now(), "Too many subscription requests, please try again later."})
+		return
+	}
+	if err := s.subscriptions.Subscribe(page.Callsign, page.Email); err != nil {
+		log.Println("Unable to send confirmation email:", err)
+		renderDashboard(w, 500, "message", messagePage{"Email alerts", time.Now(), "Unable to send the confirmation email, please try again later."})
+		return
+	}
+	renderDashboard(w, 200, "message", messagePage{"Email alerts", time.Now(),
+		"We sent a confirmation link to " + page.Email + ". Alerts for " + page.Callsign + " start once you follow it."})
+}
+
+// confirmForm and unsubscribeForm only show a button that POSTs the token
+// back, so mail scanners and link previews that fetch links do not change
+// anything.
+func (s webServer) confirmForm(w http.ResponseWriter, r *http.Request) {
+	renderDashboard(w, 200, "confirm", confirmPage{
+		Title:   "Email alerts",
+		Now:     time.Now(),
+		Message: "Start sending alerts to your address?",
+		Action:  "/subscribe/confirm",
+		Token:   r.URL.Query().Get("token"),
+		Button:  "Confirm",
+	})
+}
+
+func (s webServer) unsubscribeForm(w http.ResponseWriter, r *http.Request) {
+	renderDashboard(w, 200, "confirm", confirmPage{
+		Title:   "Email alerts",
+		Now:     time.Now(),
+		Message: "Stop sending alerts to your address?",
+		Action:  "/unsubscribe",
+		Token:   r.URL.Query().Get("token"),
+		Button:  "Unsubscribe",
+	})
+}
+
+func (s webServer) confirmSubscription(w http.ResponseWriter, r *http.Request) {
+	callsign, email, err := s.subscriptions.Confirm(r.FormValue("token"))
+	if err == nil {
+		s.audit(ActorSelfService, "subscription.add", callsign, email)
+	}
+	s.subscriptionResult(w, err, "Alerts for "+callsign+" will be sent to "+email+".")
+}
+
+func (s webServer) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	callsign, email, err := s.subscriptions.Unsubscribe(r.FormValue("token"))
+	if err == nil {
+		s.audit(ActorSelfService, "subscription.remove", callsign, email)
+	}
+	s.subscriptionResult(w, err, email+" will no longer receive alerts for "+callsign+".")
+}
+
+func (s webServer) subscriptionResult(w http.ResponseWriter, err error, message string) {
+	status := 200
+	if err == ErrInvalidToken {
+		status = 400
+		message = "This link is invalid or has expired."
+	} else if err != nil {
+		log.Println("Unable to update subscription:", err)
+		status = 500
+		message = "Unable to update your subscription, please try again later."
+	}
+	renderDashboard(w, status, "message", messagePage{"Email alerts", time.Now(), message})
+}
+
+// clientIP is the host part of r's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// renderDashboard renders into a buffer first so a template error turns into
+// a 501 instead of half a page.
+func renderDashboard(w http.ResponseWriter, status int, name string, data interface{}) {
+	buf := bytes.Buffer{}
+	if err := dashboardTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(fmt.Sprint("Unable to render page: ", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+const layoutTemplate = `{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - sentry</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 60em; padding: 0 1em; color: #222; }
+nav { padding: 1em 0; border-bottom: 1px solid #ccc; margin-bottom: 1em; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; }
+th[data-sort] { cursor: pointer; user-select: none; }
+th[data-sort]:after { content: " \2195"; color: #aaa; }
+.up { color: #080; font-weight: bold; }
+.down { color: #c00; font-weight: bold; }
+.muted { color: #777; }
+.filters { margin-bottom: 1em; }
+.filters input, .filters select { font-size: 1em; padding: 0.2em; }
+#map { height: 70vh; }
+</style>
+</head>
+<body>
+<nav><a href="/">Nodes</a><a href="/map">Map</a></nav>
+{{end}}
+
+{{define "footer"}}<p class="muted">Rendered {{timestamp .Now}}</p>
+</body>
+</html>
+{{end}}`
+
+const indexTemplate = `{{template "header" .}}
+<h1>Nodes</h1>
+<p><span class="up">{{.Live}} up</span>, <span class="down">{{.Dead}} down</span></p>
+{{if .Subscribe}}<p>Own a node? <a href="/subscribe">Get an email when it goes down.</a></p>{{end}}
+<div class="filters">
+<input id="filter" type="search" placeholder="Filter by callsign or comment">
+<select id="state">
+<option value="">All</option>
+<option value="up">Up</option>
+<option value="down">Down</option>
+</select>
+</div>
+<table id="nodes">
+<thead>
+<tr>
+<th data-sort="text">Callsign</th>
+<th data-sort="text">State</th>
+<th data-sort="number">Last seen</th>
+<th data-sort="text">Comment</th>
+</tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr data-state="{{.State}}">
+<td data-value="{{.Callsign}}"><a href="/node/{{.Callsign}}">{{.Callsign}}</a></td>
+<td data-value="{{.State}}" class="{{.State}}">{{.State}}</td>
+<td data-value="{{unix .LastSeen}}" title="{{timestamp .LastSeen}}">{{ago $.Now .LastSeen}}</td>
+<td data-value="{{with .Node}}{{.Comment}}{{end}}">{{with .Node}}{{.Comment}}{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="4" class="muted">No nodes heard yet</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+(function() {
+	var table = document.getElementById("nodes");
+	var body = table.tBodies[0];
+	var filter = document.getElementById("filter");
+	var state = document.getElementById("state");
+
+	function apply() {
+		var text = filter.value.toLowerCase();
+		Array.prototype.forEach.call(body.rows, function(row) {
+			if (!row.dataset.state) {
+				return;
+			}
+			var matches = row.textContent.toLowerCase().indexOf(text) >= 0 &&
+				(state.value === "" || row.dataset.state === state.value);
+			row.style.display = matches ? "" : "none";
+		});
+	}
+	filter.addEventListener("input", apply);
+	state.addEventListener("change", apply);
+
+	Array.prototype.forEach.call(table.tHead.rows[0].cells, function(th, column) {
+		var ascending = true;
+		th.addEventListener("click", function() {
+			var numeric = th.dataset.sort === "number";
+			var rows = Array.prototype.slice.call(body.rows);
+			rows.sort(function(a, b) {
+				var x = a.cells[column].dataset.value, y = b.cells[column].dataset.value;
+				var order = numeric ? x - y : x.localeCompare(y);
+				return ascending ? order : -order;
+			});
+			ascending = !ascending;
+			rows.forEach(function(row) { body.appendChild(row); });
+		});
+	});
+})();
+</script>
+{{template "footer" .}}`
+
+const nodeTemplate = `{{template "header" .}}
+<h1>{{.Row.Callsign}} <span class="{{.Row.State}}">{{.Row.State}}</span></h1>
+<table>
+<tr><th>Last seen</th><td title="{{timestamp .Row.LastSeen}}">{{ago .Now .Row.LastSeen}} ({{timestamp .Row.LastSeen}})</td></tr>
+{{with .Row.Node}}<tr><th>Position</th><td><a href="/map?node={{.Callsign}}">{{printf "%.5f, %.5f" .Latitude .Longitude}}</a>{{with .Altitude}}, {{printf "%.0f" .}} m{{end}}</td></tr>
+<tr><th>Position updated</th><td>{{timestamp .Updated}}</td></tr>
+<tr><th>Symbol</th><td>{{.SymbolTable}}{{.SymbolCode}}</td></tr>
+{{with .Comment}}<tr><th>Comment</th><td>{{.}}</td></tr>{{end}}
+{{with .Path}}<tr><th>Path</th><td>{{range $i, $hop := .}}{{if $i}}, {{end}}{{$hop}}{{end}}</td></tr>{{end}}
+{{with .Igate}}<tr><th>Igate</th><td>{{.}}</td></tr>{{end}}
+{{end}}</table>
+
+<h2>History</h2>
+<table>
+<thead><tr><th>When</th><th>Event</th><th>Outage</th></tr></thead>
+<tbody>
+{{range .Events}}<tr>
+<td title="{{timestamp .Timestamp}}">{{timestamp .Timestamp}}</td>
+<td>{{.Event}}</td>
+<td>{{if .Outage}}{{duration .Outage}}{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="3" class="muted">No recorded transitions</td></tr>
+{{end}}</tbody>
+</table>
+{{template "footer" .}}`
+
+// mapTemplate loads Leaflet from unpkg pinned by Subresource Integrity
+// hashes, so the browser refuses anything but the exact release. Update the
+// hashes together with the version.
+const mapTemplate = `{{template "header" .}}
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css" integrity="sha256-p4NxAoJBhIIN+hmNHrzRCf9tD/miZyoHS5obTRR9BMY=" crossorigin="">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js" integrity="sha256-20nQCchB9co0qIjJZRGuk2/Z9VM+kNiyxNV1lvTlZBo=" crossorigin=""></script>
+<h1>Map</h1>
+<p><span class="up">&#9679; up</span> <span class="down">&#9679; down</span></p>
+<div id="map"></div>
+<script>
+(function() {
+	var map = L.map("map").setView([0, 0], 2);
+	L.tileLayer("https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", {
+		attribution: "&copy; OpenStreetMap contributors"
+	}).addTo(map);
+
+	var focus = new URLSearchParams(window.location.search).get("node");
+	var bounds = L.latLngBounds([]);
+	var colors = {up: "#080", down: "#c00"};
+
+	function load(url) {
+		return fetch(url).then(function(res) { return res.json(); }).then(function(data) {
+			L.geoJSON(data, {
+				pointToLayer: function(feature, latlng) {
+					bounds.extend(latlng);
+					var marker = L.circleMarker(latlng, {radius: 7, color: colors[feature.properties.state], fillOpacity: 0.7});
+					if (feature.properties.callsign === focus) {
+						map.setView(latlng, 12);
+						setTimeout(function() { marker.openPopup(); }, 0);
+					}
+					return marker;
+				},
+				onEachFeature: function(feature, layer) {
+					var p = feature.properties;
+					var link = document.createElement("a");
+					link.href = "/node/" + encodeURIComponent(p.callsign);
+					link.textContent = p.callsign;
+					var popup = document.createElement("div");
+					popup.appendChild(link);
+					popup.appendChild(document.createTextNode(" " + p.state + ", last seen " + p.last_seen));
+					layer.bindPopup(popup);
+				}
+			}).addTo(map);
+		});
+	}
+
+	Promise.all([load("/api/live.geojson"), load("/api/dead.geojson")]).then(function() {
+		if (!focus && bounds.isValid()) {
+			map.fitBounds(bounds, {maxZoom: 12});
+		}
+	});
+})();
+</script>
+{{template "footer" .}}`
+
+const subscribeTemplate = `{{template "header" .}}
+<h1>Email alerts</h1>
+<p>Get an email when your node goes down and when it comes back. We will send a
+link to confirm the address first.</p>
+{{with .Error}}<p class="down">{{.}}</p>{{end}}
+<form method="post" action="/subscribe">
+<p><label>Callsign<br><input name="callsign" value="{{.Callsign}}" placeholder="N0CALL-10" required></label></p>
+<p><label>Email<br><input name="email" type="email" value="{{.Email}}" required></label></p>
+<p><button type="submit">Subscribe</button></p>
+</form>
+<p class="muted">Every alert includes a link to unsubscribe.</p>
+{{template "footer" .}}`
+
+const confirmTemplate = `{{template "header" .}}
+<h1>{{.Title}}</h1>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>{{.Message}}</p>
+<p><button type="submit">{{.Button}}</button></p>
+</form>
+{{template "footer" .}}`
+
+const messageTemplate = `{{template "header" .}}
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{template "footer" .}}`
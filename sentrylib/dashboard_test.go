@@ -0,0 +1,72 @@
+package sentrylib
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "text/html; charset=utf-8")
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `<a href="/node/N0CALL-1">N0CALL-1</a>`)
+	assert.Contains(t, body, `<a href="/node/N0CALL-2">N0CALL-2</a>`)
+	assert.Contains(t, body, `<a href="/node/N0CALL-3">N0CALL-3</a>`)
+	assert.Contains(t, body, "2 up")
+	assert.Contains(t, body, "1 down")
+}
+
+func TestDashboard_EscapesComment(t *testing.T) {
+	ws := newMapTestServer(t)
+	assert.NilError(t, ws.store.AddNode(sentry_store.NodeInfo{Callsign: "N0CALL-1", Comment: "<script>"}))
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(), "&lt;script&gt;")
+}
+
+func TestDashboardNode(t *testing.T) {
+	ws := newMapTestServer(t)
+	ts := time.Now().Add(-time.Hour)
+	assert.NilError(t, ws.store.AddEvent(sentry_store.CallsignEvent{Callsign: "N0CALL-1", Event: sentry_store.EventCameBack, Timestamp: ts, Outage: 90 * time.Minute}))
+
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/node/N0CALL-1", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	body := rec.Body.String()
+	assert.Contains(t, body, "came-back")
+	assert.Contains(t, body, "1h30m0s")
+	assert.Contains(t, body, "37.50000, -122.25000")
+	assert.Contains(t, body, "digi")
+}
+
+func TestDashboardNode_NotFound(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/node/N0CALL-9", nil))
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+}
+
+func TestDashboardMap(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/map", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(), "/api/live.geojson")
+	// the CDN copy of Leaflet is pinned
+	assert.Equal(t, strings.Count(rec.Body.String(), `integrity="sha256-`), 2)
+}
+
+func TestRoundDuration(t *testing.T) {
+	assert.Equal(t, roundDuration(90*time.Second+300*time.Millisecond), "1m30s")
+	assert.Equal(t, roundDuration(2*time.Hour+5*time.Minute+7*time.Second), "2h5m0s")
+}
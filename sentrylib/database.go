@@ -0,0 +1,94 @@
+package sentrylib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_bolt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_golevel"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_pg"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_rethink"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_sqlite"
+	"gopkg.in/gorethink/gorethink.v3"
+)
+
+// storeBackend names the configured database for metrics labels.
+func storeBackend(config Config) string {
+	switch {
+	case config.BoltConfig != nil:
+		return "bolt"
+	case config.PostgresConfig != nil:
+		return "postgres"
+	case config.GoLevelDBConfig != nil:
+		return "goleveldb"
+	case config.RethinkDBConfig != nil:
+		return "rethinkdb"
+	case config.SqliteConfig != nil:
+		return "sqlite"
+	default:
+		return "unknown"
+	}
+}
+
+// OpenStore opens the single database configured in config.
+func OpenStore(config Config) (sentry_store.Store, error) {
+	dbcount := 0
+	for _, configured := range []bool{
+		config.BoltConfig != nil,
+		config.PostgresConfig != nil,
+		config.GoLevelDBConfig != nil,
+		config.RethinkDBConfig != nil,
+		config.SqliteConfig != nil,
+	} {
+		if configured {
+			dbcount++
+		}
+	}
+	if dbcount != 1 {
+		return nil, errors.New("There should be one database configured")
+	}
+
+	switch {
+	case config.BoltConfig != nil:
+		return sentry_bolt.NewBoltStore(config.BoltConfig.File)
+	case config.PostgresConfig != nil:
+		connString := postgresConnString(*config.PostgresConfig)
+		if config.PostgresConfig.DisableAutoMigrate {
+			return sentry_pg.OpenPostgresDB(connString)
+		}
+		return sentry_pg.NewPostgresDB(connString)
+	case config.GoLevelDBConfig != nil:
+		return sentry_goleveldb.NewGoLevelDB(config.GoLevelDBConfig.File)
+	case config.RethinkDBConfig != nil:
+		opts := gorethink.ConnectOpts{}
+		if config.RethinkDBConfig.Address != "" {
+			opts.Address = config.RethinkDBConfig.Address
+		}
+		if config.RethinkDBConfig.Username != "" {
+			opts.Username = config.RethinkDBConfig.Username
+		}
+		if config.RethinkDBConfig.Password != "" {
+			opts.Password = config.RethinkDBConfig.Password
+		}
+		return sentry_rethink.NewRethinkDB(opts, config.RethinkDBConfig.Database)
+	default:
+		return sentry_sqlite.NewSqliteStore(config.SqliteConfig.File)
+	}
+}
+
+// MigrateDatabase applies pending schema migrations to the configured
+// Postgres database and returns the schema version before and after. The
+// other backends create their schema when opened.
+func MigrateDatabase(config Config) (int, int, error) {
+	if config.PostgresConfig == nil {
+		return 0, 0, errors.New("Migrations only apply to PostgresConfig databases")
+	}
+	return sentry_pg.Migrate(postgresConnString(*config.PostgresConfig))
+}
+
+func postgresConnString(config PostgresConfig) string {
+	if config.ConnString != "" {
+		return config.ConnString
+	}
+	return fmt.Sprintf("user=%s password='%s' host=%s dbname=%s sslmode=%s", config.User, config.Password, config.Host, config.DbName, config.SslMode)
+}
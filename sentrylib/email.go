@@ -1,9 +1,62 @@
 package sentrylib
 
 import (
+	"errors"
 	"time"
 )
 
+// Mail sends alert and subscription mails. unsubscribe is a link appended to
+// alerts so owners can opt out; it is empty when subscriptions are disabled.
 type Mail interface {
-	Send(email, callsign string, ts time.Time) error
+	Send(email, callsign string, ts time.Time, unsubscribe string) error
+	SendRecovery(email, callsign string, ts time.Time, outage time.Duration, unsubscribe string) error
+	SendConfirmation(email, callsign, link string) error
+}
+
+// NewMail returns the mail backend selected in config. Exactly one backend
+// must be configured.
+func NewMail(config Config) (Mail, error) {
+	mailcount := 0
+	var mail Mail
+	if config.Mailgun != nil {
+		mailcount++
+		mail = NewMailgunServer(config)
+	}
+	if config.Smtp != nil {
+		mailcount++
+		mail = NewSmtpServer(config)
+	}
+	if mailcount != 1 {
+		return nil, errors.New("There should be one mail backend configured")
+	}
+	return mail, nil
+}
+
+func downMessage(callsign string, ts time.Time, unsubscribe string) (string, string) {
+	body := "Hello, your APRS node '" + callsign + "' appears to be down as of " + ts.UTC().String() + "\n\n"
+	body = body + "To see your most recently sent packets, please see:\n" +
+		"http://aprs.fi/?c=raw&call=" + callsign
+	return callsign + " appears to be down", body + unsubscribeFooter(unsubscribe)
+}
+
+func recoveryMessage(callsign string, ts time.Time, outage time.Duration, unsubscribe string) (string, string) {
+	body := "Hello, your APRS node '" + callsign + "' is back online as of " + time.Now().UTC().String() + "\n\n"
+	body = body + "It was last heard at " + ts.UTC().String() + " and was down for " + (outage / time.Second * time.Second).String() + ".\n\n"
+	body = body + "To see your most recently sent packets, please see:\n" +
+		"http://aprs.fi/?c=raw&call=" + callsign
+	return callsign + " is back online", body + unsubscribeFooter(unsubscribe)
+}
+
+func confirmationMessage(callsign, link string) (string, string) {
+	body := "Hello, someone asked to send alerts for the APRS node '" + callsign + "' to this address.\n\n"
+	body = body + "To confirm, please visit:\n" + link + "\n\n"
+	body = body + "If this wasn't you, you can ignore this email and nothing will be sent."
+	return "Confirm alerts for " + callsign, body
+}
+
+func unsubscribeFooter(unsubscribe string) string {
+	if unsubscribe == "" {
+		return ""
+	}
+	return "\n\nTo stop receiving these emails, visit:\n" + unsubscribe
 }
@@ -0,0 +1,140 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"net/http"
+	"time"
+)
+
+// healthChecker answers /healthz and /readyz.
+//
+// Ready means sentry is doing its job right now: connected to APRS-IS, a
+// frame arrived within the stall timeout and the store answers. Healthy is
+// looser and only fails once restarting is likely to help: the store is
+// broken, or no frame has arrived for several stall timeouts even though the
+// client keeps redialing on its own.
+type healthChecker struct {
+	store          sentry_store.Store
+	client         AprsClient
+	mailConfigured bool
+	stallTimeout   time.Duration
+	started        time.Time
+}
+
+// livenessStalls is how many stall timeouts without a frame /healthz
+// tolerates before failing.
+const livenessStalls = 3
+
+type healthReport struct {
+	Status string           `json:"status"`
+	Aprs   aprsHealthReport `json:"aprs"`
+	Store  storeReport      `json:"store"`
+	Mail   mailReport       `json:"mail"`
+}
+
+type aprsHealthReport struct {
+	Connected           bool       `json:"connected"`
+	Receiving           bool       `json:"receiving"`
+	Server              string     `json:"server,omitempty"`
+	ConnectedSince      *time.Time `json:"connected_since,omitempty"`
+	LastFrame           *time.Time `json:"last_frame,omitempty"`
+	LastFrameAgeSeconds *float64   `json:"last_frame_age_seconds,omitempty"`
+	Reconnects          int        `json:"reconnects"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+type storeReport struct {
+	Ok           bool       `json:"ok"`
+	LastSeenLive *time.Time `json:"last_seen_live,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+type mailReport struct {
+	Configured bool `json:"configured"`
+}
+
+func newHealthChecker(config Config, store sentry_store.Store, client AprsClient) (*healthChecker, error) {
+	stallTimeout, err := parseOptionalDuration(config.AprsStallTimeout, "AprsStallTimeout")
+	if err != nil {
+		return nil, err
+	}
+	if stallTimeout <= 0 {
+		stallTimeout = 5 * time.Minute
+	}
+	return &healthChecker{
+		store:          store,
+		client:         client,
+		mailConfigured: config.Mailgun != nil || config.Smtp != nil,
+		stallTimeout:   stallTimeout,
+		started:        time.Now(),
+	}, nil
+}
+
+// check returns the current report along with whether sentry is live and
+// whether it is ready.
+func (checker *healthChecker) check() (healthReport, bool, bool) {
+	now := time.Now()
+	report := healthReport{Mail: mailReport{checker.mailConfigured}}
+
+	// without a frame yet, measure from startup so a fresh process is not
+	// killed before it had a chance to connect
+	lastFrame := checker.started
+	if checker.client != nil {
+		status := checker.client.Status()
+		report.Aprs.Connected = status.Connected
+		report.Aprs.Server = status.Server
+		report.Aprs.Reconnects = status.Reconnects
+		report.Aprs.LastError = status.LastError
+		if !status.ConnectedSince.IsZero() {
+			report.Aprs.ConnectedSince = &status.ConnectedSince
+		}
+		if !status.LastPacket.IsZero() {
+			lastFrame = status.LastPacket
+			age := now.Sub(status.LastPacket).Seconds()
+			report.Aprs.LastFrame = &status.LastPacket
+			report.Aprs.LastFrameAgeSeconds = &age
+			report.Aprs.Receiving = status.Connected && now.Sub(status.LastPacket) <= checker.stallTimeout
+		}
+	}
+
+	ts, err := checker.store.LastSeenLive()
+	if err != nil {
+		report.Store.Error = err.Error()
+	} else {
+		report.Store.Ok = true
+		report.Store.LastSeenLive = &ts
+	}
+
+	live := report.Store.Ok && now.Sub(lastFrame) <= livenessStalls*checker.stallTimeout
+	ready := report.Store.Ok && report.Aprs.Receiving
+	return report, live, ready
+}
+
+func (checker *healthChecker) healthz(w http.ResponseWriter, r *http.Request) {
+	report, live, _ := checker.check()
+	writeHealth(w, report, live)
+}
+
+func (checker *healthChecker) readyz(w http.ResponseWriter, r *http.Request) {
+	report, _, ready := checker.check()
+	writeHealth(w, report, ready)
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport, ok bool) {
+	report.Status = "ok"
+	status := http.StatusOK
+	if !ok {
+		report.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	res, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
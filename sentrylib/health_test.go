@@ -0,0 +1,109 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// statusClient is an AprsClient that only reports a fixed status.
+type statusClient struct {
+	AprsClient
+	status AprsStatus
+}
+
+func (client *statusClient) Status() AprsStatus {
+	return client.status
+}
+
+// brokenStore fails LastSeenLive like a database that went away.
+type brokenStore struct {
+	sentry_store.Store
+}
+
+func (store brokenStore) LastSeenLive() (time.Time, error) {
+	return time.Time{}, errors.New("connection refused")
+}
+
+func checkHealth(t *testing.T, checker *healthChecker, path string) (int, healthReport) {
+	router := http.NewServeMux()
+	router.HandleFunc("/healthz", checker.healthz)
+	router.HandleFunc("/readyz", checker.readyz)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	report := healthReport{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestHealth_Receiving(t *testing.T) {
+	client := &statusClient{status: AprsStatus{
+		Server:         "rotate.aprs2.net:14580",
+		Connected:      true,
+		ConnectedSince: time.Now().Add(-time.Hour),
+		LastPacket:     time.Now().Add(-time.Second),
+	}}
+	checker, err := newHealthChecker(Config{Smtp: &SmtpConfig{}}, sentry_memory.NewMemoryStore(), client)
+	assert.NilError(t, err)
+
+	code, report := checkHealth(t, checker, "/readyz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, report.Status, "ok")
+	assert.Equal(t, report.Aprs.Receiving, true)
+	assert.Equal(t, report.Aprs.Server, "rotate.aprs2.net:14580")
+	assert.NotNil(t, report.Aprs.LastFrame)
+	assert.Equal(t, report.Store.Ok, true)
+	assert.Equal(t, report.Mail.Configured, true)
+
+	code, _ = checkHealth(t, checker, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestHealth_Stalled(t *testing.T) {
+	client := &statusClient{status: AprsStatus{Connected: true, LastPacket: time.Now().Add(-10 * time.Minute)}}
+	checker, err := newHealthChecker(Config{AprsStallTimeout: "5m"}, sentry_memory.NewMemoryStore(), client)
+	assert.NilError(t, err)
+
+	// not ready, but the client should get a chance to redial
+	code, report := checkHealth(t, checker, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, report.Status, "unavailable")
+	assert.Equal(t, report.Aprs.Receiving, false)
+	assert.Equal(t, report.Mail.Configured, false)
+	code, _ = checkHealth(t, checker, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+
+	client.status.LastPacket = time.Now().Add(-time.Hour)
+	code, _ = checkHealth(t, checker, "/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+}
+
+func TestHealth_StartingUp(t *testing.T) {
+	checker, err := newHealthChecker(Config{}, sentry_memory.NewMemoryStore(), &statusClient{})
+	assert.NilError(t, err)
+
+	code, report := checkHealth(t, checker, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, report.Aprs.Connected, false)
+	code, _ = checkHealth(t, checker, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestHealth_StoreFailing(t *testing.T) {
+	client := &statusClient{status: AprsStatus{Connected: true, LastPacket: time.Now()}}
+	checker, err := newHealthChecker(Config{}, brokenStore{sentry_memory.NewMemoryStore()}, client)
+	assert.NilError(t, err)
+
+	code, report := checkHealth(t, checker, "/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, report.Store.Ok, false)
+	assert.Equal(t, report.Store.Error, "connection refused")
+	code, _ = checkHealth(t, checker, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+}
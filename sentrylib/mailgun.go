@@ -19,17 +19,29 @@ func NewMailgunServer(config Config) Mail {
 	}
 }
 
-func (mail *mailgunWrapper) Send(email, callsign string, ts time.Time) error {
-	body := "Hello, your APRS node '" + callsign + "' appears to be down as of " + ts.UTC().String() + "\n\n"
-	body = body + "To see your most recently sent packets, please see:\n" +
-		"http://aprs.fi/?c=raw&call=" + callsign
+func (mail *mailgunWrapper) Send(email, callsign string, ts time.Time, unsubscribe string) error {
+	subject, body := downMessage(callsign, ts, unsubscribe)
+	return mail.send(email, subject, body)
+}
+
+func (mail *mailgunWrapper) SendRecovery(email, callsign string, ts time.Time, outage time.Duration, unsubscribe string) error {
+	subject, body := recoveryMessage(callsign, ts, outage, unsubscribe)
+	return mail.send(email, subject, body)
+}
+
+func (mail *mailgunWrapper) SendConfirmation(email, callsign, link string) error {
+	subject, body := confirmationMessage(callsign, link)
+	return mail.send(email, subject, body)
+}
+
+func (mail *mailgunWrapper) send(email, subject, body string) error {
 	msg := mail.mg.NewMessage(mail.fromAddress,
-		callsign+" appears to be down",
+		subject,
 		body,
 		email)
 	resp, id, err := mail.mg.Send(msg)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 	log.Printf("ID: %s Resp: %s\n", id, resp)
 	return nil
@@ -0,0 +1,29 @@
+package sentrylib
+
+import (
+	"errors"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"gopkg.in/mailgun/mailgun-go.v1"
+	"testing"
+	"time"
+)
+
+// failingMailgun builds messages like the real client but fails every send.
+type failingMailgun struct {
+	mailgun.Mailgun
+}
+
+func (mg failingMailgun) Send(m *mailgun.Message) (string, string, error) {
+	return "", "", errors.New("401 Unauthorized")
+}
+
+func TestMailgun_ReturnsSendErrors(t *testing.T) {
+	mail := &mailgunWrapper{
+		mg:          failingMailgun{mailgun.NewMailgun("example.com", "key", "pubkey")},
+		fromAddress: "sentry@example.com",
+	}
+	err := mail.Send("owner@example.com", "N0CALL-1", time.Now(), "")
+	assert.Error(t, err, "401 Unauthorized")
+	err = mail.SendConfirmation("owner@example.com", "N0CALL-1", "https://sentry.example.org/subscribe/confirm")
+	assert.Error(t, err, "401 Unauthorized")
+}
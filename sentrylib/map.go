@@ -0,0 +1,290 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"html"
+	"net/http"
+	"time"
+)
+
+// mapNode is a live or dead node joined with the last position it reported.
+type mapNode struct {
+	sentry_store.NodeInfo
+	LastSeen time.Time
+	State    string
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Callsign    string    `json:"callsign"`
+	State       string    `json:"state"`
+	LastSeen    time.Time `json:"last_seen"`
+	Updated     time.Time `json:"position_updated"`
+	SymbolTable string    `json:"symbol_table"`
+	SymbolCode  string    `json:"symbol_code"`
+	Comment     string    `json:"comment,omitempty"`
+}
+
+// mapNodes returns the entries in list that have a known position. Nodes
+// that were never heard with a position report are left off the map.
+// Positions are read with a single ListNodes rather than a lookup per entry.
+func (s webServer) mapNodes(list []sentry_store.CallsignTime, state string) ([]mapNode, error) {
+	infos, err := s.store.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]sentry_store.NodeInfo, len(infos))
+	for _, info := range infos {
+		positions[info.Callsign] = info
+	}
+	nodes := make([]mapNode, 0, len(list))
+	for _, entry := range list {
+		if node, ok := positions[entry.Callsign]; ok {
+			nodes = append(nodes, mapNode{node, entry.LastSeen, state})
+		}
+	}
+	return nodes, nil
+}
+
+func (s webServer) liveMapNodes() ([]mapNode, error) {
+	list, err := s.store.ListLive(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.mapNodes(list, StateUp)
+}
+
+func (s webServer) deadMapNodes() ([]mapNode, error) {
+	list, err := s.store.ListDead()
+	if err != nil {
+		return nil, err
+	}
+	return s.mapNodes(list, StateDown)
+}
+
+func (s webServer) liveGeoJSON(w http.ResponseWriter, r *http.Request) {
+	nodes, err := s.liveMapNodes()
+	s.writeGeoJSON(w, nodes, err)
+}
+
+func (s webServer) deadGeoJSON(w http.ResponseWriter, r *http.Request) {
+	nodes, err := s.deadMapNodes()
+	s.writeGeoJSON(w, nodes, err)
+}
+
+func (s webServer) writeGeoJSON(w http.ResponseWriter, nodes []mapNode, err error) {
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	res, err := json.MarshalIndent(newGeoJSONCollection(nodes), "", "    ")
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Write(res)
+}
+
+func newGeoJSONCollection(nodes []mapNode) geoJSONCollection {
+	collection := geoJSONCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(nodes))}
+	for _, node := range nodes {
+		// GeoJSON orders coordinates longitude, latitude, altitude
+		coordinates := []float64{node.Longitude, node.Latitude}
+		if node.Altitude != nil {
+			coordinates = append(coordinates, *node.Altitude)
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONPoint{"Point", coordinates},
+			Properties: geoJSONProperties{
+				Callsign:    node.Callsign,
+				State:       node.State,
+				LastSeen:    node.LastSeen,
+				Updated:     node.Updated,
+				SymbolTable: node.SymbolTable,
+				SymbolCode:  node.SymbolCode,
+				Comment:     node.Comment,
+			},
+		})
+	}
+	return collection
+}
+
+const kmlNamespace = "http://www.opengis.net/kml/2.2"
+
+// kmlRefreshSeconds is how often the network link asks Google Earth and
+// friends to reload /api/nodes.kml.
+const kmlRefreshSeconds = 60
+
+type kmlDocument struct {
+	XMLName  xml.Name     `xml:"kml"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Document kmlContainer `xml:"Document"`
+}
+
+type kmlContainer struct {
+	Name        string          `xml:"name"`
+	Styles      []kmlStyle      `xml:"Style,omitempty"`
+	Folders     []kmlFolder     `xml:"Folder,omitempty"`
+	NetworkLink *kmlNetworkLink `xml:"NetworkLink,omitempty"`
+}
+
+type kmlStyle struct {
+	Id    string `xml:"id,attr"`
+	Color string `xml:"IconStyle>color"`
+	Icon  string `xml:"IconStyle>Icon>href"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	TimeStamp   string         `xml:"TimeStamp>when"`
+	StyleUrl    string         `xml:"styleUrl"`
+	Data        []kmlData      `xml:"ExtendedData>Data"`
+	Point       kmlCoordinates `xml:"Point"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlCoordinates struct {
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlNetworkLink struct {
+	Name            string `xml:"name"`
+	Href            string `xml:"Link>href"`
+	RefreshMode     string `xml:"Link>refreshMode"`
+	RefreshInterval int    `xml:"Link>refreshInterval"`
+}
+
+// kmlIcon is the pushpin Google Earth ships with; it is tinted per state.
+const kmlIcon = "http://maps.google.com/mapfiles/kml/pushpin/wht-pushpin.png"
+
+// nodesKML serves every live and dead node with a known position, in one
+// folder per state.
+func (s webServer) nodesKML(w http.ResponseWriter, r *http.Request) {
+	live, err := s.liveMapNodes()
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	dead, err := s.deadMapNodes()
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	doc := kmlDocument{
+		Xmlns: kmlNamespace,
+		Document: kmlContainer{
+			Name: "sentry nodes",
+			Styles: []kmlStyle{
+				// KML colors are aabbggrr
+				{StateUp, "ff00ff00", kmlIcon},
+				{StateDown, "ff0000ff", kmlIcon},
+			},
+			Folders: []kmlFolder{
+				{"Live", kmlPlacemarks(live)},
+				{"Dead", kmlPlacemarks(dead)},
+			},
+		},
+	}
+	writeKML(w, doc)
+}
+
+// networkLinkKML serves a KML file that points at /api/nodes.kml on this
+// server and refreshes it periodically, so it can be opened once and left
+// running.
+func (s webServer) networkLinkKML(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	doc := kmlDocument{
+		Xmlns: kmlNamespace,
+		Document: kmlContainer{
+			Name: "sentry",
+			NetworkLink: &kmlNetworkLink{
+				Name:            "sentry nodes",
+				Href:            scheme + "://" + r.Host + "/api/nodes.kml",
+				RefreshMode:     "onInterval",
+				RefreshInterval: kmlRefreshSeconds,
+			},
+		},
+	}
+	writeKML(w, doc)
+}
+
+func kmlPlacemarks(nodes []mapNode) []kmlPlacemark {
+	placemarks := make([]kmlPlacemark, 0, len(nodes))
+	for _, node := range nodes {
+		point := kmlCoordinates{Coordinates: fmt.Sprintf("%f,%f", node.Longitude, node.Latitude)}
+		if node.Altitude != nil {
+			point.AltitudeMode = "absolute"
+			point.Coordinates += fmt.Sprintf(",%f", *node.Altitude)
+		}
+		placemarks = append(placemarks, kmlPlacemark{
+			Name:        node.Callsign,
+			Description: kmlDescription(node.Comment),
+			TimeStamp:   node.LastSeen.UTC().Format(time.RFC3339),
+			StyleUrl:    "#" + node.State,
+			Data: []kmlData{
+				{"state", node.State},
+				{"last_seen", node.LastSeen.UTC().Format(time.RFC3339)},
+				{"symbol", node.SymbolTable + node.SymbolCode},
+			},
+			Point: point,
+		})
+	}
+	return placemarks
+}
+
+// kmlDescription escapes an APRS comment for a placemark description. Google
+// Earth renders descriptions as HTML, and comments are whatever a station
+// chose to send.
+func kmlDescription(comment string) string {
+	return html.EscapeString(comment)
+}
+
+func writeKML(w http.ResponseWriter, doc kmlDocument) {
+	res, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		w.WriteHeader(501)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(res)
+}
@@ -0,0 +1,118 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newMapTestServer(t *testing.T) webServer {
+	store := sentry_memory.NewMemoryStore()
+	now := time.Now().Add(-time.Minute)
+	altitude := 100.0
+
+	assert.NilError(t, store.AddLiveAt("N0CALL-1", now))
+	assert.NilError(t, store.AddNode(sentry_store.NodeInfo{
+		Callsign:    "N0CALL-1",
+		Updated:     now,
+		Latitude:    37.5,
+		Longitude:   -122.25,
+		Altitude:    &altitude,
+		SymbolTable: "/",
+		SymbolCode:  "#",
+		Comment:     "digi",
+	}))
+	assert.NilError(t, store.AddDead("N0CALL-2", now))
+	assert.NilError(t, store.AddNode(sentry_store.NodeInfo{
+		Callsign:    "N0CALL-2",
+		Updated:     now,
+		Latitude:    38,
+		Longitude:   -121,
+		SymbolTable: "/",
+		SymbolCode:  "&",
+		Comment:     `<a href="http://example.com">digi</a>`,
+	}))
+	// live without a position report, left off the map
+	assert.NilError(t, store.AddLiveAt("N0CALL-3", now))
+
+	return newTestWebServer(t, store, nil)
+}
+
+func newTestWebServer(t *testing.T, store sentry_store.Store, subscriptions Subscriptions) webServer {
+	ws, err := NewWebServer(Config{}, store, nil, subscriptions)
+	assert.NilError(t, err)
+	return ws.(webServer)
+}
+
+func TestLiveGeoJSON(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/live.geojson", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/geo+json")
+
+	collection := geoJSONCollection{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+	assert.Equal(t, collection.Type, "FeatureCollection")
+	assert.Equal(t, len(collection.Features), 1)
+	feature := collection.Features[0]
+	assert.Equal(t, feature.Geometry.Type, "Point")
+	assert.DeepEqual(t, feature.Geometry.Coordinates, []float64{-122.25, 37.5, 100})
+	assert.Equal(t, feature.Properties.Callsign, "N0CALL-1")
+	assert.Equal(t, feature.Properties.State, StateUp)
+	assert.Equal(t, feature.Properties.SymbolCode, "#")
+	assert.Equal(t, feature.Properties.Comment, "digi")
+}
+
+func TestDeadGeoJSON(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/dead.geojson", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	collection := geoJSONCollection{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+	assert.Equal(t, len(collection.Features), 1)
+	assert.DeepEqual(t, collection.Features[0].Geometry.Coordinates, []float64{-121, 38})
+	assert.Equal(t, collection.Features[0].Properties.State, StateDown)
+}
+
+func TestNodesKML(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/nodes.kml", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	doc := kmlDocument{}
+	assert.NilError(t, xml.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(t, len(doc.Document.Folders), 2)
+	live := doc.Document.Folders[0].Placemarks
+	assert.Equal(t, len(live), 1)
+	assert.Equal(t, live[0].Name, "N0CALL-1")
+	assert.Equal(t, live[0].StyleUrl, "#up")
+	assert.Equal(t, live[0].Point.Coordinates, "-122.250000,37.500000,100.000000")
+	dead := doc.Document.Folders[1].Placemarks
+	assert.Equal(t, len(dead), 1)
+	assert.Equal(t, dead[0].StyleUrl, "#down")
+	// comments are shown as text, not rendered as HTML
+	assert.Equal(t, dead[0].Description, "&lt;a href=&#34;http://example.com&#34;&gt;digi&lt;/a&gt;")
+}
+
+func TestNetworkLinkKML(t *testing.T) {
+	ws := newMapTestServer(t)
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://sentry.example.org/api/sentry.kml", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	doc := kmlDocument{}
+	assert.NilError(t, xml.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.NotNil(t, doc.Document.NetworkLink)
+	assert.Equal(t, doc.Document.NetworkLink.Href, "http://sentry.example.org/api/nodes.kml")
+	assert.Equal(t, doc.Document.NetworkLink.RefreshInterval, kmlRefreshSeconds)
+}
@@ -0,0 +1,256 @@
+package sentrylib
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sentry exposes its own health in the Prometheus text format on /metrics.
+// The handful of metric types below cover what we need without pulling the
+// Prometheus client and its dependencies into vendor/.
+
+var (
+	framesReceived = newCounter("sentry_frames_received_total",
+		"Frames read from APRS-IS.")
+	framesInvalid = newCounter("sentry_frames_invalid_total",
+		"Frames that could not be parsed or had no source callsign.")
+	framesWithoutPosition = newCounter("sentry_frames_without_position_total",
+		"Valid frames that did not carry a position report.")
+	handleDuration = newHistogram("sentry_handle_duration_seconds",
+		"Time spent handling a single frame, including store access.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}, "store")
+	notificationsSent = newCounter("sentry_notifications_sent_total",
+		"Notifications delivered, by notifier.", "notifier")
+	notificationsFailed = newCounter("sentry_notifications_failed_total",
+		"Notifications that failed to deliver, by notifier.", "notifier")
+)
+
+type counter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string, labelNames ...string) *counter {
+	return &counter{name: name, help: help, labelNames: labelNames, values: make(map[string]float64)}
+}
+
+// Inc adds one to the series with the given label values, which must match
+// the label names the counter was created with.
+func (c *counter) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[formatLabels(c.labelNames, labelValues)]++
+}
+
+func (c *counter) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(buf, c.name, c.help, "counter")
+	if len(c.labelNames) == 0 && len(c.values) == 0 {
+		writeSample(buf, c.name, "", 0)
+	}
+	for _, labels := range sortedKeys(c.values) {
+		writeSample(buf, c.name, labels, c.values[labels])
+	}
+}
+
+type histogram struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, labelNames: labelNames, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	labels := formatLabels(h.labelNames, labelValues)
+	series, ok := h.series[labels]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labels] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *histogram) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(buf, h.name, h.help, "histogram")
+	labelSets := make([]string, 0, len(h.series))
+	for labels := range h.series {
+		labelSets = append(labelSets, labels)
+	}
+	sort.Strings(labelSets)
+	for _, labels := range labelSets {
+		series := h.series[labels]
+		for i, bound := range h.buckets {
+			writeSample(buf, h.name+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), float64(series.counts[i]))
+		}
+		writeSample(buf, h.name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(series.count))
+		writeSample(buf, h.name+"_sum", labels, series.sum)
+		writeSample(buf, h.name+"_count", labels, float64(series.count))
+	}
+}
+
+// valueFunc is read on every scrape, for values that already live elsewhere
+// such as the number of live nodes in the store.
+type valueFunc struct {
+	name    string
+	help    string
+	kind    string
+	collect func() (float64, error)
+}
+
+func (v valueFunc) write(buf *bytes.Buffer) {
+	value, err := v.collect()
+	if err != nil {
+		log.Println("Unable to collect", v.name+":", err)
+		return
+	}
+	writeHeader(buf, v.name, v.help, v.kind)
+	writeSample(buf, v.name, "", value)
+}
+
+type metricWriter interface {
+	write(buf *bytes.Buffer)
+}
+
+// newMetricsHandler serves the package counters along with gauges read from
+// store and client on each scrape. client may be nil.
+func newMetricsHandler(store sentry_store.Store, client AprsClient) http.Handler {
+	metrics := []metricWriter{
+		framesReceived,
+		framesInvalid,
+		framesWithoutPosition,
+		handleDuration,
+		notificationsSent,
+		notificationsFailed,
+		valueFunc{"sentry_live_nodes", "Nodes currently considered up.", "gauge", func() (float64, error) {
+			count, err := store.CountLive()
+			return float64(count), err
+		}},
+		valueFunc{"sentry_dead_nodes", "Nodes currently considered down.", "gauge", func() (float64, error) {
+			count, err := store.CountDead()
+			return float64(count), err
+		}},
+	}
+	if client != nil {
+		metrics = append(metrics,
+			valueFunc{"sentry_aprs_reconnects_total", "Times the APRS-IS connection was re-established.", "counter", func() (float64, error) {
+				return float64(client.Status().Reconnects), nil
+			}},
+			valueFunc{"sentry_aprs_connected", "1 if connected to APRS-IS.", "gauge", func() (float64, error) {
+				if client.Status().Connected {
+					return 1, nil
+				}
+				return 0, nil
+			}},
+		)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := bytes.Buffer{}
+		for _, metric := range metrics {
+			metric.write(&buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf.WriteTo(w)
+	})
+}
+
+// observeHandle records how long HandleMessage took against the named store
+// backend.
+func observeHandle(backend string, start time.Time) {
+	handleDuration.Observe(time.Since(start).Seconds(), backend)
+}
+
+// notifierName labels notifications by the kind of notifier.
+func notifierName(notifier Notifier) string {
+	switch notifier.(type) {
+	case *mailNotifier:
+		return "mail"
+	case *webhookNotifier:
+		return "webhook"
+	case *aprsMessenger:
+		return "aprs"
+	default:
+		return fmt.Sprintf("%T", notifier)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(names), len(values)))
+	}
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(buf *bytes.Buffer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(buf, "%s %s\n", name, formatFloat(value))
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package sentrylib
+
+import (
+	"bytes"
+	"context"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCounter_Write(t *testing.T) {
+	c := newCounter("test_total", "Test counter.", "kind")
+	c.Inc("b")
+	c.Inc("a")
+	c.Inc("b")
+	buf := bytes.Buffer{}
+	c.write(&buf)
+	assert.Equal(t, buf.String(), "# HELP test_total Test counter.\n"+
+		"# TYPE test_total counter\n"+
+		"test_total{kind=\"a\"} 1\n"+
+		"test_total{kind=\"b\"} 2\n")
+}
+
+func TestCounter_WritesZeroWithoutLabels(t *testing.T) {
+	buf := bytes.Buffer{}
+	newCounter("test_total", "Test counter.").write(&buf)
+	assert.Contains(t, buf.String(), "\ntest_total 0\n")
+}
+
+func TestHistogram_Write(t *testing.T) {
+	h := newHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "store")
+	h.Observe(0.05, "bolt")
+	h.Observe(0.5, "bolt")
+	h.Observe(5, "bolt")
+	buf := bytes.Buffer{}
+	h.write(&buf)
+	assert.Equal(t, buf.String(), "# HELP test_seconds Test histogram.\n"+
+		"# TYPE test_seconds histogram\n"+
+		"test_seconds_bucket{store=\"bolt\",le=\"0.1\"} 1\n"+
+		"test_seconds_bucket{store=\"bolt\",le=\"1\"} 2\n"+
+		"test_seconds_bucket{store=\"bolt\",le=\"+Inf\"} 3\n"+
+		"test_seconds_sum{store=\"bolt\"} 5.55\n"+
+		"test_seconds_count{store=\"bolt\"} 3\n")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	assert.NilError(t, store.AddLive("N0CALL-1"))
+	assert.NilError(t, store.AddLive("N0CALL-2"))
+	assert.NilError(t, store.AddDead("N0CALL-3", time.Now()))
+	framesReceived.Inc()
+
+	rec := httptest.NewRecorder()
+	newMetricsHandler(store, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Contains(t, body, "\nsentry_live_nodes 2\n")
+	assert.Contains(t, body, "\nsentry_dead_nodes 1\n")
+	assert.Contains(t, body, "# TYPE sentry_frames_received_total counter\n")
+	assert.Contains(t, body, "# TYPE sentry_handle_duration_seconds histogram\n")
+}
+
+func TestNotifierName(t *testing.T) {
+	assert.Equal(t, notifierName(NewMailNotifier(sentry_memory.NewMemoryStore(), &fakeMail{}, nil)), "mail")
+	assert.Equal(t, notifierName(&webhookNotifier{}), "webhook")
+}
+
+// counterValue reads one series of a global counter.
+func counterValue(c *counter, labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[formatLabels(c.labelNames, labelValues)]
+}
+
+func TestNotificationMetrics_CountOnlyDeliveries(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	mail := &fakeMail{}
+	worker := NewSentryWorker(store, time.Hour, nil, []Notifier{NewMailNotifier(store, mail, nil)}, true)
+	sent := counterValue(notificationsSent, "mail")
+	failed := counterValue(notificationsFailed, "mail")
+
+	// nobody registered for N0CALL-1, so there is nothing to deliver
+	worker.NotifyDown(context.Background(), "N0CALL-1", time.Now())
+	assert.Equal(t, len(mail.sent), 0)
+	assert.Equal(t, counterValue(notificationsSent, "mail"), sent)
+	assert.Equal(t, counterValue(notificationsFailed, "mail"), failed)
+
+	assert.NilError(t, store.AddEmail("N0CALL-2", "owner@example.com"))
+	worker.NotifyDown(context.Background(), "N0CALL-2", time.Now())
+	assert.Equal(t, len(mail.sent), 1)
+	assert.Equal(t, counterValue(notificationsSent, "mail"), sent+1)
+	assert.Equal(t, counterValue(notificationsFailed, "mail"), failed)
+}
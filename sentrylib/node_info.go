@@ -0,0 +1,245 @@
+package sentrylib
+
+import (
+	"github.com/dustin/go-aprs"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var altitudeRegexp = regexp.MustCompile(`/A=(-?\d{5,6})`)
+
+// data extensions that may follow an uncompressed position: course/speed,
+// power-height-gain, range and direction finding
+var dataExtensionRegexp = regexp.MustCompile(`^(\d{3}/\d{3}|PHG\d{4}|RNG\d{4}|DFS\d{4})`)
+
+var qConstructRegexp = regexp.MustCompile(`^q[A-Z]{2}$`)
+
+// base-91 altitude at the start of a Mic-E comment, in meters above -10000
+var micEAltitudeRegexp = regexp.MustCompile(`^([!-{]{3})\}`)
+
+// DHM timestamp that may open a status report
+var statusTimestampRegexp = regexp.MustCompile(`^\d{6}z`)
+
+// framePosition returns the position in a frame. go-aprs does not decode
+// Mic-E, whose latitude is carried in the destination address.
+func framePosition(frame aprs.Frame) (aprs.Position, error) {
+	switch frame.Body.Type() {
+	case '`', '\'':
+		return micEPosition(frame)
+	}
+	return frame.Body.Position()
+}
+
+// NewNodeInfo collects the metadata sentry keeps from a position report.
+func NewNodeInfo(frame aprs.Frame, pos aprs.Position, ts time.Time) sentry_store.NodeInfo {
+	comment, altitude := positionComment(frame.Body)
+	path, igate := splitPath(frame.Path)
+	return sentry_store.NodeInfo{
+		Callsign:    frame.Source.String(),
+		Updated:     ts,
+		Latitude:    pos.Lat,
+		Longitude:   pos.Lon,
+		Altitude:    altitude,
+		SymbolTable: symbolString(pos.Symbol.Table),
+		SymbolCode:  symbolString(pos.Symbol.Symbol),
+		Comment:     comment,
+		Path:        path,
+		Igate:       igate,
+	}
+}
+
+func symbolString(b byte) string {
+	if b == 0 {
+		return ""
+	}
+	return string(b)
+}
+
+// splitPath separates the digipeater path from the APRS-IS q construct and
+// the igate callsign that follows it, e.g. WIDE1-1*,qAR,N0CALL-10.
+func splitPath(addresses []aprs.Address) ([]string, string) {
+	path := make([]string, 0, len(addresses))
+	for i, address := range addresses {
+		hop := address.String()
+		if qConstructRegexp.MatchString(hop) {
+			if i+1 < len(addresses) {
+				return path, addresses[i+1].String()
+			}
+			return path, ""
+		}
+		path = append(path, hop)
+	}
+	return path, ""
+}
+
+// positionComment returns the free text after the position in a position
+// report, with any data extension and /A= altitude removed. The altitude is
+// converted from feet to meters.
+func positionComment(body aprs.Info) (string, *float64) {
+	data := string(body)
+	switch body.Type() {
+	case '`', '\'':
+		return micEComment(body)
+	case '!', '=':
+		data = data[1:]
+	case '/', '@':
+		if len(data) < 8 {
+			return "", nil
+		}
+		data = data[8:]
+	default:
+		return "", nil
+	}
+	if len(data) == 0 {
+		return "", nil
+	}
+
+	if data[0] >= '0' && data[0] <= '9' {
+		// uncompressed: lat:8 symtab:1 lon:9 sym:1
+		if len(data) < 19 {
+			return "", nil
+		}
+		data = data[19:]
+		if ext := dataExtensionRegexp.FindString(data); ext != "" {
+			data = data[len(ext):]
+		}
+	} else {
+		// compressed: symtab:1 lat:4 lon:4 sym:1 cs:2 type:1
+		if len(data) < 13 {
+			return "", nil
+		}
+		data = data[13:]
+	}
+
+	var altitude *float64
+	if match := altitudeRegexp.FindStringSubmatch(data); match != nil {
+		feet, err := strconv.ParseFloat(match[1], 64)
+		if err == nil {
+			meters := feet * 0.3048
+			altitude = &meters
+		}
+		data = strings.Replace(data, match[0], "", 1)
+	}
+	return strings.TrimSpace(data), altitude
+}
+
+// micEPosition decodes a Mic-E position report. The destination address
+// holds the latitude digits and the north, longitude offset and west flags;
+// the body holds the longitude, speed, course and symbol.
+func micEPosition(frame aprs.Frame) (aprs.Position, error) {
+	dest := frame.Dest.Call
+	body := string(frame.Body)
+	if len(dest) != 6 || len(body) < 9 {
+		return aprs.Position{}, aprs.ErrNoPosition
+	}
+
+	pos := aprs.Position{}
+	digits := make([]float64, 6)
+	for i := 0; i < 6; i++ {
+		c := dest[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits[i] = float64(c - '0')
+		case c >= 'A' && c <= 'J':
+			digits[i] = float64(c - 'A')
+		case c >= 'P' && c <= 'Y':
+			digits[i] = float64(c - 'P')
+		case c == 'K' || c == 'L' || c == 'Z':
+			// position ambiguity, the digit is left out
+			pos.Ambiguity++
+		default:
+			return aprs.Position{}, aprs.ErrNoPosition
+		}
+	}
+	pos.Lat = digits[0]*10 + digits[1] + (digits[2]*10+digits[3]+(digits[4]*10+digits[5])/100)/60
+	if dest[3] < 'P' {
+		pos.Lat = -pos.Lat
+	}
+
+	degrees := int(body[1]) - 28
+	if dest[4] >= 'P' {
+		degrees += 100
+	}
+	if degrees >= 180 && degrees <= 189 {
+		degrees -= 80
+	} else if degrees >= 190 && degrees <= 199 {
+		degrees -= 190
+	}
+	minutes := int(body[2]) - 28
+	if minutes >= 60 {
+		minutes -= 60
+	}
+	hundredths := int(body[3]) - 28
+	if degrees < 0 || degrees > 179 || minutes < 0 || hundredths < 0 || hundredths > 99 {
+		return aprs.Position{}, aprs.ErrNoPosition
+	}
+	pos.Lon = float64(degrees) + (float64(minutes)+float64(hundredths)/100)/60
+	if dest[5] >= 'P' {
+		pos.Lon = -pos.Lon
+	}
+	if pos.Lat < -90 || pos.Lat > 90 {
+		return aprs.Position{}, aprs.ErrNoPosition
+	}
+
+	sp, dc, se := int(body[4])-28, int(body[5])-28, int(body[6])-28
+	speed := sp*10 + dc/10
+	if speed >= 800 {
+		speed -= 800
+	}
+	course := dc%10*100 + se
+	if course >= 400 {
+		course -= 400
+	}
+	pos.Velocity = aprs.Velocity{Course: float64(course), Speed: float64(speed) * 1.852}
+	pos.Symbol = aprs.Symbol{Table: body[8], Symbol: body[7]}
+	return pos, nil
+}
+
+// micEComment returns the free text of a Mic-E report, without the radio
+// type indicator and suffix. A base-91 altitude is returned in meters.
+func micEComment(body aprs.Info) (string, *float64) {
+	if len(body) <= 9 {
+		return "", nil
+	}
+	data := string(body[9:])
+
+	switch data[0] {
+	case '>', ']':
+		// Kenwood, with an optional model suffix
+		data = strings.TrimRight(data[1:], "=^")
+	case '`', '\'':
+		// other manufacturers append a two character model code
+		data = data[1:]
+		if n := len(data); n >= 2 && strings.IndexByte("_|:(", data[n-2]) >= 0 {
+			data = data[:n-2]
+		}
+	}
+
+	var altitude *float64
+	if match := micEAltitudeRegexp.FindStringSubmatch(data); match != nil {
+		value := 0
+		for _, c := range []byte(match[1]) {
+			value = value*91 + int(c-33)
+		}
+		meters := float64(value - 10000)
+		altitude = &meters
+		data = data[len(match[0]):]
+	}
+	return strings.TrimSpace(data), altitude
+}
+
+// statusText returns the text of a status report, without its optional
+// timestamp.
+func statusText(body aprs.Info) string {
+	if body.Type() != '>' {
+		return ""
+	}
+	data := string(body[1:])
+	if statusTimestampRegexp.MatchString(data) {
+		data = data[7:]
+	}
+	return strings.TrimSpace(data)
+}
@@ -0,0 +1,104 @@
+package sentrylib
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/dustin/go-aprs"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"testing"
+	"time"
+)
+
+func TestNewNodeInfo_Uncompressed(t *testing.T) {
+	frame := aprs.ParseFrame("N0CALL-1>APRS,WIDE1-1*,WIDE2-1,qAR,N0CALL-10:!3722.10N/12159.10W#PHG5360/A=001234 Mountain top digi")
+	pos, err := frame.Body.Position()
+	assert.NilError(t, err)
+	ts := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	node := NewNodeInfo(frame, pos, ts)
+	assert.Equal(t, node.Callsign, "N0CALL-1")
+	assert.Equal(t, node.Updated, ts)
+	assert.Equal(t, node.SymbolTable, "/")
+	assert.Equal(t, node.SymbolCode, "#")
+	assert.Equal(t, node.Comment, "Mountain top digi")
+	assert.DeepEqual(t, node.Path, []string{"WIDE1-1*", "WIDE2-1"})
+	assert.Equal(t, node.Igate, "N0CALL-10")
+	assert.NotNil(t, node.Altitude)
+	assert.Equal(t, int(*node.Altitude*10), 3761)
+}
+
+func TestNewNodeInfo_CompressedWithTimestamp(t *testing.T) {
+	frame := aprs.ParseFrame("N0CALL-9>APRS,TCPIP*,qAC,T2TEST:@092345z/5L!!<*e7>7P[Weather station")
+	pos, err := frame.Body.Position()
+	assert.NilError(t, err)
+
+	node := NewNodeInfo(frame, pos, time.Now())
+	assert.Equal(t, node.SymbolTable, "/")
+	assert.Equal(t, node.SymbolCode, ">")
+	assert.Equal(t, node.Comment, "Weather station")
+	assert.Equal(t, node.Altitude == nil, true)
+	assert.DeepEqual(t, node.Path, []string{"TCPIP*"})
+	assert.Equal(t, node.Igate, "T2TEST")
+}
+
+func TestNewNodeInfo_MicE(t *testing.T) {
+	frame := aprs.ParseFrame("N0CALL-9>S32U6T,WIDE1-1*,qAR,N0CALL-10:`(_fn\"Oj/]\"4T}Mobile 146.520MHz=")
+	_, err := frame.Body.Position()
+	assert.Equal(t, err, aprs.ErrNoPosition)
+	pos, err := framePosition(frame)
+	assert.NilError(t, err)
+	assert.Equal(t, fmt.Sprintf("%.4f %.4f", pos.Lat, pos.Lon), "33.4273 -12.1290")
+	assert.Equal(t, pos.Velocity.Course, 251.0)
+	assert.Equal(t, fmt.Sprintf("%.2f", pos.Velocity.Speed), "37.04")
+
+	node := NewNodeInfo(frame, pos, time.Now())
+	assert.Equal(t, node.SymbolTable, "/")
+	assert.Equal(t, node.SymbolCode, "j")
+	assert.Equal(t, node.Comment, "Mobile 146.520MHz")
+	assert.NotNil(t, node.Altitude)
+	assert.Equal(t, *node.Altitude, 61.0)
+	assert.DeepEqual(t, node.Path, []string{"WIDE1-1*"})
+	assert.Equal(t, node.Igate, "N0CALL-10")
+
+	frame = aprs.ParseFrame("N0CALL-7>S32U6T,qAR,N0CALL-10:'(_fn\"Oj/`Trail run_%")
+	pos, err = framePosition(frame)
+	assert.NilError(t, err)
+	node = NewNodeInfo(frame, pos, time.Now())
+	assert.Equal(t, node.Comment, "Trail run")
+	assert.Equal(t, node.Altitude == nil, true)
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, statusText(aprs.ParseFrame("N0CALL>APRS:>Net tonight at 8").Body), "Net tonight at 8")
+	assert.Equal(t, statusText(aprs.ParseFrame("N0CALL>APRS:>092345zNet tonight at 8").Body), "Net tonight at 8")
+	assert.Equal(t, statusText(aprs.ParseFrame("N0CALL>APRS:!3722.10N/12159.10W#").Body), "")
+}
+
+func TestSentryWorker_StatusUpdatesComment(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	worker := NewSentryWorker(store, time.Hour, nil, []Notifier{&fakeNotifier{}}, true)
+	ctx := context.Background()
+
+	err := worker.HandleMessage(ctx, aprs.ParseFrame("N0CALL>APRS:>Going to the hamfest"))
+	assert.Equal(t, err, aprs.ErrNoPosition)
+	_, ok, err := store.GetNode("N0CALL")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	assert.NilError(t, worker.HandleMessage(ctx, aprs.ParseFrame("N0CALL>APRS:!3722.10N/12159.10W#Home")))
+	err = worker.HandleMessage(ctx, aprs.ParseFrame("N0CALL>APRS:>092345zGoing to the hamfest"))
+	assert.Equal(t, err, aprs.ErrNoPosition)
+	node, ok, err := store.GetNode("N0CALL")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, node.Comment, "Going to the hamfest")
+	assert.Equal(t, fmt.Sprintf("%.2f", node.Latitude), "37.37")
+
+	// a position without a comment keeps the status
+	assert.NilError(t, worker.HandleMessage(ctx, aprs.ParseFrame("N0CALL>APRS:!3722.20N/12159.10W#")))
+	node, _, err = store.GetNode("N0CALL")
+	assert.NilError(t, err)
+	assert.Equal(t, node.Comment, "Going to the hamfest")
+	assert.Equal(t, fmt.Sprintf("%.4f", node.Latitude), "37.3700")
+}
@@ -0,0 +1,86 @@
+package sentrylib
+
+import (
+	"context"
+	"errors"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"time"
+)
+
+const (
+	StateDown = "down"
+	StateUp   = "up"
+)
+
+// Notification describes a node changing state. LastSeen is when the node
+// was last heard before going down; Outage is only set when State is StateUp.
+type Notification struct {
+	Callsign string
+	State    string
+	LastSeen time.Time
+	Outage   time.Duration
+	Position *Position
+}
+
+type Position struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// ErrNoRecipient is returned by a Notifier that had nobody to deliver a
+// notification to, such as a node without a registered owner. It is not a
+// failure, but nothing was sent either.
+var ErrNoRecipient = errors.New("No recipient for notification")
+
+// Notifier delivers Notifications. Implementations decide for themselves
+// whether a notification is relevant, e.g. mail is only sent to registered
+// owners, and return ErrNoRecipient when it is not. Retries should stop once
+// ctx is done.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+type mailNotifier struct {
+	store         sentry_store.EmailAddressStore
+	mail          Mail
+	subscriptions Subscriptions
+}
+
+// NewMailNotifier sends notifications to the address registered for the
+// callsign, if there is one. If subscriptions is not nil each address gets
+// its own mail with an unsubscribe link.
+func NewMailNotifier(store sentry_store.EmailAddressStore, mail Mail, subscriptions Subscriptions) Notifier {
+	return &mailNotifier{
+		store:         store,
+		mail:          mail,
+		subscriptions: subscriptions,
+	}
+}
+
+func (notifier *mailNotifier) Notify(ctx context.Context, notification Notification) error {
+	email, ok, err := notifier.store.GetEmail(notification.Callsign)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoRecipient
+	}
+	if notifier.subscriptions == nil {
+		return notifier.send(email, notification, "")
+	}
+	var lastErr error
+	for _, address := range splitAddresses(email) {
+		err := notifier.send(address, notification, notifier.subscriptions.UnsubscribeLink(notification.Callsign, address))
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (notifier *mailNotifier) send(email string, notification Notification, unsubscribe string) error {
+	if notification.State == StateUp {
+		return notifier.mail.SendRecovery(email, notification.Callsign, notification.LastSeen, notification.Outage, unsubscribe)
+	}
+	return notifier.mail.Send(email, notification.Callsign, notification.LastSeen, unsubscribe)
+}
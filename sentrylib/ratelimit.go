@@ -0,0 +1,54 @@
+package sentrylib
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimitKeys bounds how many keys a rateLimiter tracks before it drops
+// the ones whose window has passed.
+const maxRateLimitKeys = 10000
+
+// rateLimiter allows each key at most limit events within a sliding window.
+// It only lives in memory, so limits reset when sentry restarts.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for key at now, unless key already used up its
+// limit, in which case it returns false and records nothing.
+func (limiter *rateLimiter) Allow(key string, now time.Time) bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	start := now.Add(-limiter.window)
+	if len(limiter.events) >= maxRateLimitKeys {
+		for k, events := range limiter.events {
+			if !events[len(events)-1].After(start) {
+				delete(limiter.events, k)
+			}
+		}
+	}
+
+	events := limiter.events[key]
+	for len(events) > 0 && !events[0].After(start) {
+		events = events[1:]
+	}
+	if len(events) >= limiter.limit {
+		limiter.events[key] = events
+		return false
+	}
+	limiter.events[key] = append(events, now)
+	return true
+}
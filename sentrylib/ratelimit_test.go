@@ -0,0 +1,33 @@
+package sentrylib
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Hour)
+	now := time.Now()
+
+	assert.Equal(t, limiter.Allow("a", now), true)
+	assert.Equal(t, limiter.Allow("a", now.Add(time.Minute)), true)
+	assert.Equal(t, limiter.Allow("a", now.Add(2*time.Minute)), false)
+	// other keys have their own limit
+	assert.Equal(t, limiter.Allow("b", now.Add(2*time.Minute)), true)
+	// rejected events do not count, so the first event leaving the window
+	// frees a slot
+	assert.Equal(t, limiter.Allow("a", now.Add(time.Hour)), true)
+	assert.Equal(t, limiter.Allow("a", now.Add(time.Hour+time.Second)), false)
+}
+
+func TestRateLimiter_DropsExpiredKeys(t *testing.T) {
+	limiter := newRateLimiter(1, time.Minute)
+	now := time.Now()
+	for i := 0; i < maxRateLimitKeys; i++ {
+		limiter.Allow(strconv.Itoa(i), now)
+	}
+	assert.Equal(t, limiter.Allow("late", now.Add(time.Hour)), true)
+	assert.Equal(t, len(limiter.events), 1)
+}
@@ -1,24 +1,19 @@
 package sentrylib
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"github.com/dustin/go-aprs"
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
-	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_bolt"
-	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_golevel"
-	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_pg"
-	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_rethink"
-	"gopkg.in/gorethink/gorethink.v3"
 	"gopkg.in/yaml.v2"
-	"io"
 	"log"
-	"os"
-	"syscall"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Sentry interface {
-	Serve() error
+	Serve(ctx context.Context) error
 }
 
 type sentry struct {
@@ -31,70 +26,76 @@ func NewSentry(config Config) Sentry {
 	}
 }
 
-func (server *sentry) Serve() error {
+// Serve runs until ctx is done, then stops ingest, the reaper and the
+// watchdog, waits for pending notifications, shuts down the web servers and
+// finally closes the store.
+func (server *sentry) Serve(ctx context.Context) error {
 	log.SetFlags(log.Flags() | log.Llongfile)
-	client := NewAprsClient(server.config.AprsServer, server.config.AprsUser, server.config.AprsPasscode, server.config.AprsFilter)
+	filter := server.config.AprsFilter
+	if server.config.AprsMessage != nil {
+		// acks for our messages only arrive if the filter lets them through
+		filter = strings.TrimSpace(filter + " g/" + strings.ToUpper(server.config.AprsUser))
+	}
+	servers := server.config.AprsServers
+	if server.config.AprsServer != "" {
+		servers = append([]string{server.config.AprsServer}, servers...)
+	}
+	readTimeout, err := parseOptionalDuration(server.config.AprsReadTimeout, "AprsReadTimeout")
+	if err != nil {
+		return err
+	}
+	stallTimeout, err := parseOptionalDuration(server.config.AprsStallTimeout, "AprsStallTimeout")
+	if err != nil {
+		return err
+	}
+	client := NewAprsClient(servers, server.config.AprsUser, server.config.AprsPasscode, filter, readTimeout, stallTimeout)
 
-	mout, _ := yaml.Marshal(server.config)
+	mout, _ := yaml.Marshal(server.config.Redacted())
 	log.Println(string(mout))
 
-	dbcount := 0
-	var store sentry_store.Store
-	var err error
-	if server.config.BoltConfig != nil {
-		dbcount++
-		store, err = sentry_bolt.NewBoltStore(server.config.BoltConfig.File)
-	}
-	if server.config.PostgresConfig != nil {
-		dbcount++
-		connString := ""
-		if server.config.PostgresConfig.ConnString != "" {
-			connString = server.config.PostgresConfig.ConnString
-		} else {
-			user := server.config.PostgresConfig.User
-			password := server.config.PostgresConfig.Password
-			host := server.config.PostgresConfig.Host
-			dbname := server.config.PostgresConfig.DbName
-			sslmode := server.config.PostgresConfig.SslMode
-			connString = fmt.Sprintf("user=%s password='%s' host=%s dbname=%s sslmode=%s", user, password, host, dbname, sslmode)
-		}
-		log.Println(connString)
-		store, err = sentry_pg.NewPostgresDB(connString)
-	}
-	if server.config.GoLevelDBConfig != nil {
-		dbcount++
-		store, err = sentry_goleveldb.NewGoLevelDB(server.config.GoLevelDBConfig.File)
+	store, err := OpenStore(server.config)
+	if err != nil {
+		return err
 	}
-	if server.config.RethinkDBConfig != nil {
-		dbcount++
-		opts := gorethink.ConnectOpts{}
-		if server.config.RethinkDBConfig.Address != "" {
-			opts.Address = server.config.RethinkDBConfig.Address
+	defer func() {
+		err := store.Close()
+		if err != nil {
+			log.Println("Unable to close database:", err)
 		}
-		if server.config.RethinkDBConfig.Username != "" {
-			opts.Username = server.config.RethinkDBConfig.Username
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var messenger AprsMessenger
+	if server.config.AprsMessage != nil {
+		messenger, err = NewAprsMessenger(ctx, store, client, server.config)
+		if err != nil {
+			return err
 		}
-		if server.config.RethinkDBConfig.Password != "" {
-			opts.Password = server.config.RethinkDBConfig.Password
+	}
+
+	var mail Mail
+	if server.config.Mailgun != nil || server.config.Smtp != nil {
+		mail, err = NewMail(server.config)
+		if err != nil {
+			return err
 		}
-		store, err = sentry_rethink.NewRethinkDB(opts, server.config.RethinkDBConfig.Database)
 	}
-	if dbcount != 1 {
-		log.Fatalln("There should be one database configured")
+
+	var subscriptions Subscriptions
+	if server.config.Subscription != nil {
+		subscriptions, err = NewSubscriptions(server.config, store, mail)
+		if err != nil {
+			return err
+		}
 	}
 
-	//store, err := sentry_rethink.NewRethinkDB("localhost", "dev")
-	//store, err := sentry_goleveldb.NewGoLevelDB("level.db")
-	//store, err := sentry_pg.NewPostgresDB("sentry")
+	notifiers, err := newNotifiers(server.config, store, mail, subscriptions, messenger)
 	if err != nil {
 		return err
 	}
 
-	mail := NewMailgunServer(server.config)
-
-	// runs in background
-	NewWebServer(store)
-
 	duration := 25 * time.Hour
 	if server.config.Cutoff != "" {
 		duration, err = time.ParseDuration(server.config.Cutoff)
@@ -103,75 +104,233 @@ func (server *sentry) Serve() error {
 		}
 	}
 
-	worker := NewSentryWorker(store, duration, mail)
+	var adaptive *AdaptiveCutoff
+	if server.config.Adaptive != nil {
+		adaptive, err = newAdaptiveCutoff(*server.config.Adaptive, duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	web, err := NewWebServer(server.config, store, client, subscriptions)
+	if err != nil {
+		return err
+	}
+	// a web server that cannot listen or dies takes sentry down with it
+	webDone := make(chan error, 1)
+	go func() {
+		err := web.Serve(ctx)
+		if err != nil {
+			log.Println("Web server failed:", err)
+			cancel()
+		}
+		webDone <- err
+	}()
+
+	worker := NewSentryWorker(store, duration, adaptive, notifiers, server.config.SkipCooldown)
+
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		RunReaper(ctx, worker)
+	}()
+	go func() {
+		defer background.Done()
+		Watchdog(ctx, worker, client)
+	}()
 
-	go RunReaper(worker, duration, server.config.SkipCooldown)
+	// unblocks client.Next once we are asked to stop
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
 
-	go Watchdog(worker)
+	err = server.ingest(ctx, client, worker, messenger)
 
+	log.Println("Shutting down")
+	cancel()
+	background.Wait()
+	worker.Wait()
+	webErr := <-webDone
+	if err == nil {
+		err = webErr
+	}
+	return err
+}
+
+// ingest feeds packets from APRS-IS to the worker, redialing whenever the
+// stream ends, until ctx is done.
+func (server *sentry) ingest(ctx context.Context, client AprsClient, worker SentryWorker, messenger AprsMessenger) error {
+	backend := storeBackend(server.config)
 	for {
-		err = client.Dial()
+		err := client.Dial(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		count := 0
-		totalTime := 0 * time.Second
 		for client.Next() {
+			framesReceived.Inc()
 			frame, err := client.Frame()
 			if err != nil {
 				log.Println(err)
 			}
-			ts1 := time.Now()
-			err = worker.HandleMessage(frame)
-			ts2 := time.Now()
-			dur := ts2.Sub(ts1)
-			count++
-			totalTime += dur
-			avg := time.Duration(int64(totalTime) / int64(count))
-			log.Println("\t\t\t\t\t", avg, dur)
-			if err != nil {
-				if !(err == FrameNotValidError || err.Error() == "no positions found") {
-					log.Println(err)
-				}
+			if messenger != nil {
+				messenger.HandleFrame(frame)
+			}
+			start := time.Now()
+			err = worker.HandleMessage(ctx, frame)
+			observeHandle(backend, start)
+			switch err {
+			case nil:
+			case FrameNotValidError, EmptyCallsignError:
+				framesInvalid.Inc()
+			case aprs.ErrNoPosition:
+				framesWithoutPosition.Inc()
+			default:
+				log.Println(err)
 			}
 		}
-		err = client.Error()
-		if err != io.EOF {
-			return err
-		} else {
-			log.Println("Redial Triggered:", err)
+		if ctx.Err() != nil {
+			return nil
 		}
+		log.Println("Redial Triggered:", client.Error())
 	}
 }
 
-func RunReaper(sentryWorker SentryWorker, duration time.Duration, skipCooldown bool) {
-	if !skipCooldown {
-		time.Sleep(duration)
-	}
+// maxPendingDownNotifications bounds how many down notifications the reaper
+// sends at once.
+const maxPendingDownNotifications = 8
+
+// RunReaper reaps live nodes once a second until ctx is done. Down
+// notifications are sent in the background, so a slow notifier does not hold
+// up reaping; once maxPendingDownNotifications are in flight the reaper waits
+// for one to finish. RunReaper returns after every notification it started
+// has finished.
+func RunReaper(ctx context.Context, sentryWorker SentryWorker) {
+	var pending sync.WaitGroup
+	defer pending.Wait()
+	slots := make(chan struct{}, maxPendingDownNotifications)
 	for {
 		nodes, err := sentryWorker.ReapLiveNodes()
 		if err != nil {
 			log.Println(err)
-			continue
 		}
 		for _, v := range nodes {
-			sentryWorker.Email(v.Callsign, v.LastSeen)
+			slots <- struct{}{}
+			pending.Add(1)
+			go func(node sentry_store.CallsignTime) {
+				defer func() {
+					<-slots
+					pending.Done()
+				}()
+				sentryWorker.NotifyDown(ctx, node.Callsign, node.LastSeen)
+			}(v)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(1 * time.Second):
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
 
-func Watchdog(sentryWorker SentryWorker) {
+// Watchdog periodically reports the health of the APRS-IS stream and the
+// database. Stalled connections are detected and redialed by the client
+// itself; the watchdog only logs.
+func Watchdog(ctx context.Context, sentryWorker SentryWorker, client AprsClient) {
 	for {
-		time.Sleep(1 * time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(1 * time.Minute):
+		}
+		now := time.Now()
+		status := client.Status()
+		if !status.Connected {
+			log.Println("Watchdog: not connected to APRS-IS, attempts:", status.Attempts, "last error:", status.LastError)
+		} else if status.LastPacket.IsZero() {
+			log.Println("Watchdog: connected to", status.Server, "since", status.ConnectedSince, "but no packets yet")
+		} else {
+			log.Println("Watchdog: connected to", status.Server, "last packet", now.Sub(status.LastPacket), "ago")
+		}
 		ts, err := sentryWorker.LastSeen()
 		if err != nil {
-			log.Fatalln("Unable to access database\n", err)
+			log.Println("Watchdog: unable to access database:", err)
+			continue
+		}
+		log.Println("Watchdog: last live update", now.Sub(ts), "ago")
+	}
+}
+
+// parseOptionalDuration returns 0 for an empty value so callers can apply
+// their own default.
+func parseOptionalDuration(value, name string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New("Unable to parse " + name + " in config")
+	}
+	return duration, nil
+}
+
+// newNotifiers builds every notifier enabled in config. Mail is optional as
+// long as some other notifier is configured. mail, subscriptions and
+// messenger may be nil.
+func newNotifiers(config Config, store sentry_store.Store, mail Mail, subscriptions Subscriptions, messenger AprsMessenger) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0)
+	if mail != nil {
+		notifiers = append(notifiers, NewMailNotifier(store, mail, subscriptions))
+	}
+	if config.Webhook != nil {
+		webhook, err := NewWebhookNotifier(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	if messenger != nil {
+		notifiers = append(notifiers, messenger)
+	}
+	if len(notifiers) == 0 {
+		return nil, errors.New("There should be at least one notifier configured")
+	}
+	return notifiers, nil
+}
+
+// newAdaptiveCutoff fills in defaults for an AdaptiveConfig. The ceiling
+// defaults to the global cutoff so slow nodes are never held longer than today.
+func newAdaptiveCutoff(config AdaptiveConfig, cutoff time.Duration) (*AdaptiveCutoff, error) {
+	factor := config.MissedBeacons
+	if factor <= 0 {
+		factor = 3
+	}
+	minSamples := config.MinSamples
+	if minSamples <= 0 {
+		minSamples = 5
+	}
+	floor := 30 * time.Minute
+	if config.Floor != "" {
+		var err error
+		floor, err = time.ParseDuration(config.Floor)
+		if err != nil {
+			return nil, errors.New("Unable to parse Adaptive.Floor in config")
 		}
-		log.Println(time.Now(), ts, time.Now().Sub(ts))
-		if time.Now().Sub(ts) > time.Minute {
-			log.Println("Stream failed and did not close connection, restart")
-			syscall.Exec("sentry", os.Args, os.Environ())
+	}
+	ceiling := cutoff
+	if config.Ceiling != "" {
+		var err error
+		ceiling, err = time.ParseDuration(config.Ceiling)
+		if err != nil {
+			return nil, errors.New("Unable to parse Adaptive.Ceiling in config")
 		}
 	}
+	if floor > ceiling {
+		return nil, errors.New("Adaptive.Floor must not be greater than Adaptive.Ceiling")
+	}
+	return NewAdaptiveCutoff(factor, minSamples, floor, ceiling), nil
 }
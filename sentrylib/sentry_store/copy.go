@@ -0,0 +1,264 @@
+package sentry_store
+
+import "time"
+
+// CopyStats counts the records Copy, Export or Import handled.
+type CopyStats struct {
+	Live    int
+	Dead    int
+	Emails  int
+	Cutoffs int
+	Nodes   int
+	Events  int
+	Tokens  int
+	Audit   int
+}
+
+func (stats *CopyStats) count(recordType string) {
+	switch recordType {
+	case recordLive:
+		stats.Live++
+	case recordDead:
+		stats.Dead++
+	case recordEmail:
+		stats.Emails++
+	case recordCutoff:
+		stats.Cutoffs++
+	case recordNode:
+		stats.Nodes++
+	case recordEvent:
+		stats.Events++
+	case recordToken:
+		stats.Tokens++
+	case recordAudit:
+		stats.Audit++
+	}
+}
+
+// Copy writes every live, dead, email, cutoff, node, history, token and audit
+// record in src to dst, keeping timestamps. Existing records in dst with the
+// same callsign or token name are overwritten, the history of every callsign
+// found in src replaces the history dst had for it, and audit entries dst
+// already has are skipped, so running Copy twice does not duplicate anything.
+func Copy(dst, src Store) (CopyStats, error) {
+	w := newRecordWriter(dst)
+	err := walk(src, w.write)
+	return w.stats, err
+}
+
+// walk calls fn for every record in src, grouped by type in the order they
+// appear in an export.
+func walk(src Store, fn func(record) error) error {
+	live, err := src.ListLive(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, v := range live {
+		if err := fn(record{Type: recordLive, Callsign: v.Callsign, LastSeen: timePtr(v.LastSeen)}); err != nil {
+			return err
+		}
+	}
+
+	dead, err := src.ListDead()
+	if err != nil {
+		return err
+	}
+	for _, v := range dead {
+		if err := fn(record{Type: recordDead, Callsign: v.Callsign, LastSeen: timePtr(v.LastSeen)}); err != nil {
+			return err
+		}
+	}
+
+	emails, err := src.ListEmail()
+	if err != nil {
+		return err
+	}
+	for _, v := range emails {
+		if err := fn(record{Type: recordEmail, Callsign: v.Callsign, Email: v.Email}); err != nil {
+			return err
+		}
+	}
+
+	cutoffs, err := src.ListCutoff()
+	if err != nil {
+		return err
+	}
+	for _, v := range cutoffs {
+		if err := fn(record{Type: recordCutoff, Callsign: v.Callsign, Cutoff: v.Cutoff.String()}); err != nil {
+			return err
+		}
+	}
+
+	nodes, err := src.ListNodes()
+	if err != nil {
+		return err
+	}
+	for _, v := range nodes {
+		rec := record{
+			Type:        recordNode,
+			Callsign:    v.Callsign,
+			Updated:     timePtr(v.Updated),
+			Latitude:    floatPtr(v.Latitude),
+			Longitude:   floatPtr(v.Longitude),
+			Altitude:    v.Altitude,
+			SymbolTable: v.SymbolTable,
+			SymbolCode:  v.SymbolCode,
+			Comment:     v.Comment,
+			Path:        v.Path,
+			Igate:       v.Igate,
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	events, err := src.ListAllEvents()
+	if err != nil {
+		return err
+	}
+	for _, v := range events {
+		rec := record{Type: recordEvent, Callsign: v.Callsign, Event: string(v.Event), Timestamp: timePtr(v.Timestamp)}
+		if v.Outage != 0 {
+			rec.Outage = v.Outage.String()
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	tokens, err := src.ListTokens()
+	if err != nil {
+		return err
+	}
+	for _, v := range tokens {
+		rec := record{Type: recordToken, Name: v.Name, Hash: v.Hash, Scope: string(v.Scope), Created: timePtr(v.Created)}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	audit, err := src.ListAudit(time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, v := range audit {
+		rec := record{Type: recordAudit, Timestamp: timePtr(v.Timestamp), Actor: v.Actor, Action: v.Action, Target: v.Target, Detail: v.Detail}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordWriter stores records in dst. The first event seen for a callsign
+// clears the history dst already had for it. The audit log is append only, so
+// instead the entries dst already has are loaded on the first audit record
+// and skipped.
+type recordWriter struct {
+	dst     Store
+	cleared map[string]bool
+	audit   map[AuditEntry]bool
+	stats   CopyStats
+}
+
+func newRecordWriter(dst Store) *recordWriter {
+	return &recordWriter{
+		dst:     dst,
+		cleared: make(map[string]bool),
+	}
+}
+
+func (w *recordWriter) write(rec record) error {
+	var err error
+	switch rec.Type {
+	case recordLive:
+		err = w.dst.AddLiveAt(rec.Callsign, *rec.LastSeen)
+	case recordDead:
+		err = w.dst.AddDead(rec.Callsign, *rec.LastSeen)
+	case recordEmail:
+		err = w.dst.AddEmail(rec.Callsign, rec.Email)
+	case recordCutoff:
+		var cutoff time.Duration
+		cutoff, err = time.ParseDuration(rec.Cutoff)
+		if err == nil {
+			err = w.dst.AddCutoff(rec.Callsign, cutoff)
+		}
+	case recordNode:
+		err = w.dst.AddNode(NodeInfo{
+			Callsign:    rec.Callsign,
+			Updated:     *rec.Updated,
+			Latitude:    *rec.Latitude,
+			Longitude:   *rec.Longitude,
+			Altitude:    rec.Altitude,
+			SymbolTable: rec.SymbolTable,
+			SymbolCode:  rec.SymbolCode,
+			Comment:     rec.Comment,
+			Path:        rec.Path,
+			Igate:       rec.Igate,
+		})
+	case recordEvent:
+		event := CallsignEvent{
+			Callsign:  rec.Callsign,
+			Event:     EventType(rec.Event),
+			Timestamp: *rec.Timestamp,
+		}
+		if rec.Outage != "" {
+			event.Outage, err = time.ParseDuration(rec.Outage)
+			if err != nil {
+				return err
+			}
+		}
+		if !w.cleared[rec.Callsign] {
+			if err := w.dst.RemoveEvents(rec.Callsign); err != nil {
+				return err
+			}
+			w.cleared[rec.Callsign] = true
+		}
+		err = w.dst.AddEvent(event)
+	case recordToken:
+		err = w.dst.AddToken(ApiToken{
+			Name:    rec.Name,
+			Hash:    rec.Hash,
+			Scope:   TokenScope(rec.Scope),
+			Created: *rec.Created,
+		})
+	case recordAudit:
+		if w.audit == nil {
+			existing, err := w.dst.ListAudit(time.Time{})
+			if err != nil {
+				return err
+			}
+			w.audit = make(map[AuditEntry]bool)
+			for _, v := range existing {
+				v.Timestamp = v.Timestamp.UTC()
+				w.audit[v] = true
+			}
+		}
+		entry := AuditEntry{
+			Timestamp: rec.Timestamp.UTC(),
+			Actor:     rec.Actor,
+			Action:    rec.Action,
+			Target:    rec.Target,
+			Detail:    rec.Detail,
+		}
+		if w.audit[entry] {
+			return nil
+		}
+		w.audit[entry] = true
+		err = w.dst.AddAudit(entry)
+	}
+	if err != nil {
+		return err
+	}
+	w.stats.count(rec.Type)
+	return nil
+}
+
+func timePtr(ts time.Time) *time.Time {
+	ts = ts.UTC()
+	return &ts
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
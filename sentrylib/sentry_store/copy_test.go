@@ -0,0 +1,64 @@
+package sentry_store_test
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	src := sentry_memory.NewMemoryStore()
+	dst := sentry_memory.NewMemoryStore()
+
+	lastSeen := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	assert.NilError(t, src.AddLiveAt("FOO1", lastSeen))
+	assert.NilError(t, src.AddDead("FOO2", lastSeen.Add(-time.Hour)))
+	assert.NilError(t, src.AddEmail("FOO1", "foo@example.com"))
+	assert.NilError(t, src.AddCutoff("FOO1", 6*time.Hour))
+	assert.NilError(t, src.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO2", Event: sentry_store.EventWentDown, Timestamp: lastSeen.Add(-time.Hour)}))
+	assert.NilError(t, src.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO1", Event: sentry_store.EventFirstSeen, Timestamp: lastSeen}))
+	assert.NilError(t, src.AddToken(sentry_store.ApiToken{Name: "portal", Hash: "aa11", Scope: sentry_store.ScopeRead, Created: lastSeen}))
+	assert.NilError(t, src.AddAudit(sentry_store.AuditEntry{Timestamp: lastSeen, Actor: "portal", Action: "email.add", Target: "FOO1"}))
+
+	assert.NilError(t, dst.AddEmail("FOO1", "old@example.com"))
+	assert.NilError(t, dst.AddEmail("BAR", "bar@example.com"))
+
+	stats, err := sentry_store.Copy(dst, src)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stats, sentry_store.CopyStats{Live: 1, Dead: 1, Emails: 1, Cutoffs: 1, Events: 2, Tokens: 1, Audit: 1})
+
+	ts, ok, err := dst.GetLive("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts.Equal(lastSeen), true)
+
+	ts, ok, err = dst.GetDead("FOO2")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts.Equal(lastSeen.Add(-time.Hour)), true)
+
+	emails, err := dst.ListEmail()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, emails, []sentry_store.CallsignEmail{{"BAR", "bar@example.com"}, {"FOO1", "foo@example.com"}})
+
+	cutoff, ok, err := dst.GetCutoff("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, cutoff, 6*time.Hour)
+
+	// copying again replaces history and skips known audit entries instead of
+	// duplicating them
+	stats, err = sentry_store.Copy(dst, src)
+	assert.NilError(t, err)
+	assert.Equal(t, stats.Audit, 0)
+	audit, err := dst.ListAudit(time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(audit), 1)
+	events, err := dst.ListAllEvents()
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Callsign, "FOO1")
+	assert.Equal(t, events[1].Callsign, "FOO2")
+}
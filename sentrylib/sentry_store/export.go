@@ -0,0 +1,198 @@
+package sentry_store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Export and Import use a portable JSON Lines format: one JSON object per
+// line, each with a "type" field. The first line is always a header:
+//
+//	{"type":"header","format":"sentry-export","version":1,"exported":"2017-06-01T12:30:00Z"}
+//
+// followed by any number of records, grouped by type in this order:
+//
+//	{"type":"live","callsign":"N0CALL-1","last_seen":"2017-06-01T12:29:41.5Z"}
+//	{"type":"dead","callsign":"N0CALL-2","last_seen":"2017-05-30T08:00:00Z"}
+//	{"type":"email","callsign":"N0CALL-1","email":"owner@example.com"}
+//	{"type":"cutoff","callsign":"N0CALL-1","cutoff":"6h0m0s"}
+//	{"type":"node","callsign":"N0CALL-1","updated":"2017-06-01T12:29:41.5Z","latitude":37.368333,"longitude":-121.985,"altitude":1234.5,"symbol_table":"/","symbol_code":"#","comment":"Mountain top digi","path":["WIDE1-1"],"igate":"N0CALL-10"}
+//	{"type":"event","callsign":"N0CALL-2","event":"came-back","timestamp":"2017-05-31T09:00:00Z","outage":"25h0m0s"}
+//	{"type":"token","name":"club-portal","hash":"9f86d081884c7d65...","scope":"manage","created":"2017-05-01T10:00:00Z"}
+//	{"type":"audit","timestamp":"2017-05-31T09:05:00Z","actor":"club-portal","action":"email.add","target":"N0CALL-2","detail":"owner@example.com"}
+//
+// Timestamps are RFC 3339 in UTC. Durations use Go duration syntax. "outage"
+// is omitted when zero. Events are ordered by callsign, then time. Node
+// records carry the metadata from the last position report; altitude is in
+// meters, and altitude, comment, path and igate are omitted when unknown.
+// Token records carry the hash of the secret, never the secret itself. Audit
+// records are oldest first and "detail" is omitted when empty.
+//
+// ExportVersion is bumped whenever a record type or field is added or
+// changed. Import accepts any version up to its own and rejects newer files
+// and unknown record types rather than silently dropping data.
+//
+//	1: live, dead, email, cutoff and event records
+//	2: node records
+//	3: token and audit records
+const ExportVersion = 3
+
+const exportFormat = "sentry-export"
+
+const (
+	recordHeader = "header"
+	recordLive   = "live"
+	recordDead   = "dead"
+	recordEmail  = "email"
+	recordCutoff = "cutoff"
+	recordNode   = "node"
+	recordEvent  = "event"
+	recordToken  = "token"
+	recordAudit  = "audit"
+)
+
+// record is a single line of an export. Only the fields for its Type are set.
+type record struct {
+	Type string `json:"type"`
+
+	// header
+	Format   string     `json:"format,omitempty"`
+	Version  int        `json:"version,omitempty"`
+	Exported *time.Time `json:"exported,omitempty"`
+
+	Callsign  string     `json:"callsign,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	Cutoff    string     `json:"cutoff,omitempty"`
+	Event     string     `json:"event,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Outage    string     `json:"outage,omitempty"`
+
+	// node
+	Updated     *time.Time `json:"updated,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+	Altitude    *float64   `json:"altitude,omitempty"`
+	SymbolTable string     `json:"symbol_table,omitempty"`
+	SymbolCode  string     `json:"symbol_code,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	Path        []string   `json:"path,omitempty"`
+	Igate       string     `json:"igate,omitempty"`
+
+	// token
+	Name    string     `json:"name,omitempty"`
+	Hash    string     `json:"hash,omitempty"`
+	Scope   string     `json:"scope,omitempty"`
+	Created *time.Time `json:"created,omitempty"`
+
+	// audit, with Timestamp
+	Actor  string `json:"actor,omitempty"`
+	Action string `json:"action,omitempty"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Export writes the whole contents of src to w.
+func Export(w io.Writer, src Store) (CopyStats, error) {
+	stats := CopyStats{}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(record{
+		Type:     recordHeader,
+		Format:   exportFormat,
+		Version:  ExportVersion,
+		Exported: timePtr(time.Now()),
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = walk(src, func(rec record) error {
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+		stats.count(rec.Type)
+		return nil
+	})
+	return stats, err
+}
+
+// Import reads an export from r and writes its records to dst as it goes,
+// with the same overwrite rules as Copy. On error, records before the
+// failing line have already been written.
+func Import(r io.Reader, dst Store) (CopyStats, error) {
+	decoder := json.NewDecoder(r)
+	header := record{}
+	if err := decoder.Decode(&header); err != nil {
+		return CopyStats{}, fmt.Errorf("Line 1: %s", err)
+	}
+	if header.Type != recordHeader || header.Format != exportFormat {
+		return CopyStats{}, errors.New("Line 1: not a sentry export header")
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return CopyStats{}, fmt.Errorf("Unsupported export version %d, this build reads up to %d", header.Version, ExportVersion)
+	}
+
+	w := newRecordWriter(dst)
+	for line := 2; ; line++ {
+		rec := record{}
+		err := decoder.Decode(&rec)
+		if err == io.EOF {
+			return w.stats, nil
+		}
+		if err != nil {
+			return w.stats, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if err := validateRecord(rec); err != nil {
+			return w.stats, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if err := w.write(rec); err != nil {
+			return w.stats, fmt.Errorf("Line %d: %s", line, err)
+		}
+	}
+}
+
+func validateRecord(rec record) error {
+	switch rec.Type {
+	case recordToken:
+		if rec.Name == "" || rec.Hash == "" || rec.Scope == "" || rec.Created == nil {
+			return errors.New("missing name, hash, scope or created")
+		}
+		return nil
+	case recordAudit:
+		if rec.Timestamp == nil || rec.Actor == "" || rec.Action == "" {
+			return errors.New("missing timestamp, actor or action")
+		}
+		return nil
+	}
+	if rec.Callsign == "" {
+		return errors.New("missing callsign")
+	}
+	switch rec.Type {
+	case recordLive, recordDead:
+		if rec.LastSeen == nil {
+			return errors.New("missing last_seen")
+		}
+	case recordEmail:
+		if rec.Email == "" {
+			return errors.New("missing email")
+		}
+	case recordCutoff:
+		if rec.Cutoff == "" {
+			return errors.New("missing cutoff")
+		}
+	case recordNode:
+		if rec.Updated == nil || rec.Latitude == nil || rec.Longitude == nil {
+			return errors.New("missing updated, latitude or longitude")
+		}
+	case recordEvent:
+		if rec.Event == "" || rec.Timestamp == nil {
+			return errors.New("missing event or timestamp")
+		}
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package sentry_store_test
+
+import (
+	"bytes"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	src := sentry_memory.NewMemoryStore()
+	lastSeen := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	assert.NilError(t, src.AddLiveAt("FOO1", lastSeen))
+	assert.NilError(t, src.AddDead("FOO2", lastSeen.Add(-time.Hour)))
+	assert.NilError(t, src.AddEmail("FOO1", "foo@example.com"))
+	assert.NilError(t, src.AddCutoff("FOO1", 6*time.Hour))
+	assert.NilError(t, src.AddNode(sentry_store.NodeInfo{Callsign: "FOO1", Updated: lastSeen, Latitude: 37.5, Longitude: 0, SymbolTable: "/", SymbolCode: "#", Path: []string{"WIDE1-1"}}))
+	assert.NilError(t, src.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO1", Event: sentry_store.EventCameBack, Timestamp: lastSeen, Outage: 25 * time.Hour}))
+	assert.NilError(t, src.AddToken(sentry_store.ApiToken{Name: "portal", Hash: "aa11", Scope: sentry_store.ScopeManage, Created: lastSeen}))
+	assert.NilError(t, src.AddAudit(sentry_store.AuditEntry{Timestamp: lastSeen, Actor: "portal", Action: "email.add", Target: "FOO1", Detail: "foo@example.com"}))
+
+	var buf bytes.Buffer
+	stats, err := sentry_store.Export(&buf, src)
+	assert.NilError(t, err)
+	expected := sentry_store.CopyStats{Live: 1, Dead: 1, Emails: 1, Cutoffs: 1, Nodes: 1, Events: 1, Tokens: 1, Audit: 1}
+	assert.DeepEqual(t, stats, expected)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 9)
+	assert.Contains(t, lines[0], `"format":"sentry-export","version":3`)
+	assert.Equal(t, lines[1], `{"type":"live","callsign":"FOO1","last_seen":"2017-06-01T12:30:00Z"}`)
+	assert.Equal(t, lines[4], `{"type":"cutoff","callsign":"FOO1","cutoff":"6h0m0s"}`)
+	assert.Equal(t, lines[5], `{"type":"node","callsign":"FOO1","updated":"2017-06-01T12:30:00Z","latitude":37.5,"longitude":0,"symbol_table":"/","symbol_code":"#","path":["WIDE1-1"]}`)
+	assert.Equal(t, lines[6], `{"type":"event","callsign":"FOO1","event":"came-back","timestamp":"2017-06-01T12:30:00Z","outage":"25h0m0s"}`)
+	assert.Equal(t, lines[7], `{"type":"token","name":"portal","hash":"aa11","scope":"manage","created":"2017-06-01T12:30:00Z"}`)
+	assert.Equal(t, lines[8], `{"type":"audit","timestamp":"2017-06-01T12:30:00Z","actor":"portal","action":"email.add","target":"FOO1","detail":"foo@example.com"}`)
+
+	dst := sentry_memory.NewMemoryStore()
+	stats, err = sentry_store.Import(&buf, dst)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stats, expected)
+
+	ts, ok, err := dst.GetLive("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts.Equal(lastSeen), true)
+
+	node, ok, err := dst.GetNode("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, node.Latitude, 37.5)
+	assert.DeepEqual(t, node.Path, []string{"WIDE1-1"})
+
+	events, err := dst.ListAllEvents()
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Outage, 25*time.Hour)
+
+	token, ok, err := dst.GetToken("portal")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, token.Scope, sentry_store.ScopeManage)
+
+	audit, err := dst.ListAudit(time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(audit), 1)
+	assert.Equal(t, audit[0].Actor, "portal")
+}
+
+func TestImport_Rejects(t *testing.T) {
+	header := `{"type":"header","format":"sentry-export","version":1}` + "\n"
+
+	_, err := sentry_store.Import(strings.NewReader(`{"type":"live","callsign":"FOO"}`), sentry_memory.NewMemoryStore())
+	assert.Error(t, err, "not a sentry export header")
+
+	_, err = sentry_store.Import(strings.NewReader(`{"type":"header","format":"sentry-export","version":99}`), sentry_memory.NewMemoryStore())
+	assert.Error(t, err, "Unsupported export version 99")
+
+	_, err = sentry_store.Import(strings.NewReader(header+`{"type":"bogus","callsign":"FOO"}`), sentry_memory.NewMemoryStore())
+	assert.Error(t, err, `Line 2: unknown record type "bogus"`)
+
+	_, err = sentry_store.Import(strings.NewReader(header+`{"type":"email","callsign":"FOO","email":"a@b"}`+"\n"+`{"type":"live","callsign":"FOO"}`), sentry_memory.NewMemoryStore())
+	assert.Error(t, err, "Line 3: missing last_seen")
+
+	_, err = sentry_store.Import(strings.NewReader(header+`{"type":"token","name":"portal","hash":"aa11"}`), sentry_memory.NewMemoryStore())
+	assert.Error(t, err, "Line 2: missing name, hash, scope or created")
+}
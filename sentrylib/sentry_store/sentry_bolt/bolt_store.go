@@ -1,10 +1,14 @@
 package sentry_bolt
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"github.com/boltdb/bolt"
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -14,8 +18,11 @@ type boltStore struct {
 
 func NewBoltStore(f string) (sentry_store.Store, error) {
 	db, err := bolt.Open(f, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, errors.New("Database " + f + " is locked by another process, such as a running sentry")
+	}
 	if err != nil {
-		log.Panicln("Unable to open or create database")
+		return nil, errors.New("Unable to open or create database: " + err.Error())
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte("live"))
@@ -30,6 +37,26 @@ func NewBoltStore(f string) (sentry_store.Store, error) {
 		if err != nil {
 			return err
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("cutoffs"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("tokens"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("audit"))
+		if err != nil {
+			return err
+		}
 		return nil
 	})
 	return &boltStore{
@@ -41,6 +68,10 @@ func (store *boltStore) AddLive(callsign string) error {
 	return store.add("live", callsign, time.Now())
 }
 
+func (store *boltStore) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add("live", callsign, ts)
+}
+
 func (store *boltStore) AddDead(callsign string, ts time.Time) error {
 	return store.add("dead", callsign, ts)
 }
@@ -273,3 +304,382 @@ func (store *boltStore) ListEmail() ([]sentry_store.CallsignEmail, error) {
 	}
 	return emails, nil
 }
+
+func (store *boltStore) AddCutoff(callsign string, cutoff time.Duration) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("cutoffs"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(callsign), []byte(strconv.FormatInt(int64(cutoff), 10)))
+	})
+}
+
+func (store *boltStore) GetCutoff(callsign string) (time.Duration, bool, error) {
+	var byteResult []byte
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cutoffs"))
+		if bucket == nil {
+			return errors.New("Could not open bucket")
+		}
+		byteResult = bucket.Get([]byte(callsign))
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if byteResult == nil {
+		return 0, false, nil
+	}
+	cutoff, err := strconv.ParseInt(string(byteResult), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(cutoff), true, nil
+}
+
+func (store *boltStore) RemoveCutoff(callsign string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("cutoffs"))
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(callsign))
+	})
+}
+
+func (store *boltStore) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	cutoffs := make([]sentry_store.CallsignCutoff, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cutoffs"))
+		if bucket == nil {
+			return errors.New("Unable to open cutoffs bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			cutoff, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				continue
+			}
+			cutoffs = append(cutoffs, sentry_store.CallsignCutoff{string(k), time.Duration(cutoff)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cutoffs, nil
+}
+
+// historyKey orders events by callsign and then by time so that a cursor can
+// seek to a callsign's first event and walk forward. The bucket sequence keeps
+// events with the same timestamp apart.
+func historyKey(callsign string, ts time.Time, seq uint64) []byte {
+	key := historyPrefix(callsign)
+	suffix := make([]byte, 16)
+	binary.BigEndian.PutUint64(suffix, keyNanos(ts))
+	binary.BigEndian.PutUint64(suffix[8:], seq)
+	return append(key, suffix...)
+}
+
+func historyPrefix(callsign string) []byte {
+	return append([]byte(callsign), 0)
+}
+
+func (store *boltStore) AddEvent(event sentry_store.CallsignEvent) error {
+	event.Timestamp = event.Timestamp.UTC()
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(event.Callsign, event.Timestamp, seq), value)
+	})
+}
+
+func (store *boltStore) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	events := make([]sentry_store.CallsignEvent, 0)
+	prefix := historyPrefix(callsign)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("history"))
+		if bucket == nil {
+			return errors.New("Unable to open history bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			event := sentry_store.CallsignEvent{}
+			err := json.Unmarshal(v, &event)
+			if err != nil {
+				return err
+			}
+			if event.Timestamp.Before(since) {
+				continue
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *boltStore) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	events := make([]sentry_store.CallsignEvent, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("history"))
+		if bucket == nil {
+			return errors.New("Unable to open history bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			event := sentry_store.CallsignEvent{}
+			err := json.Unmarshal(v, &event)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *boltStore) RemoveEvents(callsign string) error {
+	prefix := historyPrefix(callsign)
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		keys := make([][]byte, 0)
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *boltStore) AddNode(node sentry_store.NodeInfo) error {
+	node.Updated = node.Updated.UTC()
+	value, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(node.Callsign), value)
+	})
+}
+
+func (store *boltStore) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	node := sentry_store.NodeInfo{}
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("nodes"))
+		if bucket == nil {
+			return errors.New("Unable to open nodes bucket")
+		}
+		value := bucket.Get([]byte(callsign))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &node)
+	})
+	if err != nil || !found {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	return node, true, nil
+}
+
+func (store *boltStore) ListNodes() ([]sentry_store.NodeInfo, error) {
+	nodes := make([]sentry_store.NodeInfo, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("nodes"))
+		if bucket == nil {
+			return errors.New("Unable to open nodes bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			node := sentry_store.NodeInfo{}
+			err := json.Unmarshal(v, &node)
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (store *boltStore) RemoveNode(callsign string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(callsign))
+	})
+}
+
+func (store *boltStore) AddToken(token sentry_store.ApiToken) error {
+	token.Created = token.Created.UTC()
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("tokens"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(token.Name), value)
+	})
+}
+
+func (store *boltStore) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	token := sentry_store.ApiToken{}
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("tokens"))
+		if bucket == nil {
+			return errors.New("Unable to open tokens bucket")
+		}
+		value := bucket.Get([]byte(name))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &token)
+	})
+	if err != nil || !found {
+		return sentry_store.ApiToken{}, false, err
+	}
+	return token, true, nil
+}
+
+func (store *boltStore) ListTokens() ([]sentry_store.ApiToken, error) {
+	tokens := make([]sentry_store.ApiToken, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("tokens"))
+		if bucket == nil {
+			return errors.New("Unable to open tokens bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			token := sentry_store.ApiToken{}
+			err := json.Unmarshal(v, &token)
+			if err != nil {
+				continue
+			}
+			tokens = append(tokens, token)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (store *boltStore) RemoveToken(name string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("tokens"))
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+// auditKey orders entries by time; the bucket sequence keeps entries with
+// the same timestamp apart. Times before 1970, including the zero time used
+// to list everything, sort first instead of wrapping around to the end.
+func auditKey(ts time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, keyNanos(ts))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// keyNanos checks the range first because UnixNano is undefined for times
+// it cannot represent, such as the zero time.
+func keyNanos(ts time.Time) uint64 {
+	if ts.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	return uint64(ts.UnixNano())
+}
+
+func (store *boltStore) AddAudit(entry sentry_store.AuditEntry) error {
+	entry.Timestamp = entry.Timestamp.UTC()
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("audit"))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(auditKey(entry.Timestamp, seq), value)
+	})
+}
+
+func (store *boltStore) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	entries := make([]sentry_store.AuditEntry, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("audit"))
+		if bucket == nil {
+			return errors.New("Unable to open audit bucket")
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(auditKey(since, 0)); k != nil; k, v = c.Next() {
+			entry := sentry_store.AuditEntry{}
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
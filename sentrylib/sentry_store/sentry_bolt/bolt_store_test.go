@@ -0,0 +1,36 @@
+package sentry_bolt_test
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_bolt"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_storetest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentry_bolt")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := sentry_bolt.NewBoltStore(filepath.Join(dir, "test.db"))
+	assert.NilError(t, err)
+	defer store.Close()
+
+	sentry_storetest.TestStore(t, store)
+}
+
+func TestBoltStore_Locked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentry_bolt")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := sentry_bolt.NewBoltStore(filepath.Join(dir, "test.db"))
+	assert.NilError(t, err)
+	defer store.Close()
+
+	_, err = sentry_bolt.NewBoltStore(filepath.Join(dir, "test.db"))
+	assert.Error(t, err, "is locked by another process")
+}
@@ -1,17 +1,23 @@
 package sentry_goleveldb
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type goLevelDB struct {
 	db *leveldb.DB
+	// seq keeps history and audit entries with the same timestamp apart
+	seq uint64
 }
 
 var NotImplementedError error = errors.New("Not Implemented")
@@ -21,8 +27,11 @@ func NewGoLevelDB(file string) (sentry_store.Store, error) {
 	if err != nil {
 		return nil, err
 	}
+	// seeding with the clock keeps sequence numbers increasing across
+	// restarts, so a new entry never replaces an old one
 	return &goLevelDB{
-		db: db,
+		db:  db,
+		seq: uint64(time.Now().UnixNano()),
 	}, nil
 }
 
@@ -30,6 +39,10 @@ func (store *goLevelDB) AddLive(callsign string) error {
 	return store.add("live", callsign, time.Now())
 }
 
+func (store *goLevelDB) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add("live", callsign, ts)
+}
+
 func (store *goLevelDB) AddDead(callsign string, ts time.Time) error {
 	return store.add("dead", callsign, ts)
 }
@@ -217,3 +230,273 @@ func (store *goLevelDB) RemoveEmail(callsign string) error {
 	key := []byte("email-" + callsign)
 	return store.db.Delete(key, nil)
 }
+
+func (store *goLevelDB) AddCutoff(callsign string, cutoff time.Duration) error {
+	return store.db.Put([]byte("cutoff-"+callsign), []byte(strconv.FormatInt(int64(cutoff), 10)), nil)
+}
+func (store *goLevelDB) GetCutoff(callsign string) (time.Duration, bool, error) {
+	val, err := store.db.Get([]byte("cutoff-"+callsign), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	cutoff, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(cutoff), true, nil
+}
+func (store *goLevelDB) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	iter := store.db.NewIterator(util.BytesPrefix([]byte("cutoff-")), nil)
+	result := make([]sentry_store.CallsignCutoff, 0)
+	for iter.Next() {
+		callsign := strings.TrimPrefix(string(iter.Key()), "cutoff-")
+		cutoff, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, sentry_store.CallsignCutoff{callsign, time.Duration(cutoff)})
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+func (store *goLevelDB) RemoveCutoff(callsign string) error {
+	return store.db.Delete([]byte("cutoff-"+callsign), nil)
+}
+
+// historyKey keeps a callsign's events contiguous and in time order, with a
+// sequence number so events with the same timestamp do not replace each
+// other. The NUL separator stops "history-FOO" from matching the events of
+// "FOO-1".
+func historyKey(callsign string, ts time.Time, seq uint64) []byte {
+	key := historyPrefix(callsign)
+	suffix := make([]byte, 16)
+	binary.BigEndian.PutUint64(suffix, keyNanos(ts))
+	binary.BigEndian.PutUint64(suffix[8:], seq)
+	return append(key, suffix...)
+}
+
+func historyPrefix(callsign string) []byte {
+	return []byte("history-" + callsign + "\x00")
+}
+
+func (store *goLevelDB) AddEvent(event sentry_store.CallsignEvent) error {
+	event.Timestamp = event.Timestamp.UTC()
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	seq := atomic.AddUint64(&store.seq, 1)
+	return store.db.Put(historyKey(event.Callsign, event.Timestamp, seq), value, nil)
+}
+
+func (store *goLevelDB) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	iter := store.db.NewIterator(util.BytesPrefix(historyPrefix(callsign)), nil)
+	result := make([]sentry_store.CallsignEvent, 0)
+	for iter.Next() {
+		event := sentry_store.CallsignEvent{}
+		err := json.Unmarshal(iter.Value(), &event)
+		if err != nil {
+			iter.Release()
+			return nil, err
+		}
+		if event.Timestamp.Before(since) {
+			continue
+		}
+		result = append(result, event)
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *goLevelDB) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	iter := store.db.NewIterator(util.BytesPrefix([]byte("history-")), nil)
+	result := make([]sentry_store.CallsignEvent, 0)
+	for iter.Next() {
+		event := sentry_store.CallsignEvent{}
+		err := json.Unmarshal(iter.Value(), &event)
+		if err != nil {
+			iter.Release()
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *goLevelDB) RemoveEvents(callsign string) error {
+	iter := store.db.NewIterator(util.BytesPrefix(historyPrefix(callsign)), nil)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return err
+	}
+	return store.db.Write(batch, nil)
+}
+
+func (store *goLevelDB) AddNode(node sentry_store.NodeInfo) error {
+	node.Updated = node.Updated.UTC()
+	value, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return store.db.Put([]byte("node-"+node.Callsign), value, nil)
+}
+
+func (store *goLevelDB) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	val, err := store.db.Get([]byte("node-"+callsign), nil)
+	if err == leveldb.ErrNotFound {
+		return sentry_store.NodeInfo{}, false, nil
+	}
+	if err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	node := sentry_store.NodeInfo{}
+	if err := json.Unmarshal(val, &node); err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	return node, true, nil
+}
+
+func (store *goLevelDB) ListNodes() ([]sentry_store.NodeInfo, error) {
+	iter := store.db.NewIterator(util.BytesPrefix([]byte("node-")), nil)
+	result := make([]sentry_store.NodeInfo, 0)
+	for iter.Next() {
+		node := sentry_store.NodeInfo{}
+		err := json.Unmarshal(iter.Value(), &node)
+		if err != nil {
+			continue
+		}
+		result = append(result, node)
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *goLevelDB) RemoveNode(callsign string) error {
+	return store.db.Delete([]byte("node-"+callsign), nil)
+}
+
+func (store *goLevelDB) AddToken(token sentry_store.ApiToken) error {
+	token.Created = token.Created.UTC()
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return store.db.Put([]byte("token-"+token.Name), value, nil)
+}
+
+func (store *goLevelDB) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	val, err := store.db.Get([]byte("token-"+name), nil)
+	if err == leveldb.ErrNotFound {
+		return sentry_store.ApiToken{}, false, nil
+	}
+	if err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	token := sentry_store.ApiToken{}
+	if err := json.Unmarshal(val, &token); err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	return token, true, nil
+}
+
+func (store *goLevelDB) ListTokens() ([]sentry_store.ApiToken, error) {
+	iter := store.db.NewIterator(util.BytesPrefix([]byte("token-")), nil)
+	result := make([]sentry_store.ApiToken, 0)
+	for iter.Next() {
+		token := sentry_store.ApiToken{}
+		err := json.Unmarshal(iter.Value(), &token)
+		if err != nil {
+			continue
+		}
+		result = append(result, token)
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *goLevelDB) RemoveToken(name string) error {
+	return store.db.Delete([]byte("token-"+name), nil)
+}
+
+// auditKey orders entries by time, then by the order they were added. Times
+// before 1970, including the zero time used to list everything, sort
+// first instead of wrapping around to the end.
+func auditKey(ts time.Time, seq uint64) []byte {
+	key := []byte("audit-")
+	suffix := make([]byte, 16)
+	binary.BigEndian.PutUint64(suffix, keyNanos(ts))
+	binary.BigEndian.PutUint64(suffix[8:], seq)
+	return append(key, suffix...)
+}
+
+// keyNanos checks the range first because UnixNano is undefined for times
+// it cannot represent, such as the zero time.
+func keyNanos(ts time.Time) uint64 {
+	if ts.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	return uint64(ts.UnixNano())
+}
+
+func (store *goLevelDB) AddAudit(entry sentry_store.AuditEntry) error {
+	entry.Timestamp = entry.Timestamp.UTC()
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	seq := atomic.AddUint64(&store.seq, 1)
+	return store.db.Put(auditKey(entry.Timestamp, seq), value, nil)
+}
+
+func (store *goLevelDB) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	iter := store.db.NewIterator(&util.Range{Start: auditKey(since, 0), Limit: []byte("audit.")}, nil)
+	result := make([]sentry_store.AuditEntry, 0)
+	for iter.Next() {
+		entry := sentry_store.AuditEntry{}
+		err := json.Unmarshal(iter.Value(), &entry)
+		if err != nil {
+			iter.Release()
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	iter.Release()
+	err := iter.Error()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *goLevelDB) Close() error {
+	return store.db.Close()
+}
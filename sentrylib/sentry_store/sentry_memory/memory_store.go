@@ -0,0 +1,361 @@
+package sentry_memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+)
+
+// memoryStore keeps everything in maps guarded by a single lock. Nothing
+// survives a restart, which makes it useful for tests and trial runs.
+type memoryStore struct {
+	mu      sync.RWMutex
+	live    map[string]time.Time
+	dead    map[string]time.Time
+	emails  map[string]string
+	cutoffs map[string]time.Duration
+	history map[string][]sentry_store.CallsignEvent
+	nodes   map[string]sentry_store.NodeInfo
+	tokens  map[string]sentry_store.ApiToken
+	audit   []sentry_store.AuditEntry
+}
+
+func NewMemoryStore() sentry_store.Store {
+	return &memoryStore{
+		live:    make(map[string]time.Time),
+		dead:    make(map[string]time.Time),
+		emails:  make(map[string]string),
+		cutoffs: make(map[string]time.Duration),
+		history: make(map[string][]sentry_store.CallsignEvent),
+		nodes:   make(map[string]sentry_store.NodeInfo),
+		tokens:  make(map[string]sentry_store.ApiToken),
+	}
+}
+
+func (store *memoryStore) AddLive(callsign string) error {
+	return store.add(store.live, callsign, time.Now())
+}
+
+func (store *memoryStore) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add(store.live, callsign, ts)
+}
+
+func (store *memoryStore) AddDead(callsign string, ts time.Time) error {
+	return store.add(store.dead, callsign, ts)
+}
+
+func (store *memoryStore) add(bucket map[string]time.Time, callsign string, ts time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bucket[callsign] = ts.UTC()
+	return nil
+}
+
+func (store *memoryStore) CountLive() (int, error) {
+	return store.count(store.live)
+}
+
+func (store *memoryStore) CountDead() (int, error) {
+	return store.count(store.dead)
+}
+
+func (store *memoryStore) count(bucket map[string]time.Time) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return len(bucket), nil
+}
+
+func (store *memoryStore) GetLive(callsign string) (time.Time, bool, error) {
+	return store.get(store.live, callsign)
+}
+
+func (store *memoryStore) GetDead(callsign string) (time.Time, bool, error) {
+	return store.get(store.dead, callsign)
+}
+
+func (store *memoryStore) get(bucket map[string]time.Time, callsign string) (time.Time, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	ts, ok := bucket[callsign]
+	return ts, ok, nil
+}
+
+func (store *memoryStore) ListLive(ts time.Time) ([]sentry_store.CallsignTime, error) {
+	return store.list(store.live, ts)
+}
+
+func (store *memoryStore) ListDead() ([]sentry_store.CallsignTime, error) {
+	return store.list(store.dead, time.Now())
+}
+
+// list returns entries last seen at or before ts, ordered by callsign like
+// the bolt and leveldb stores.
+func (store *memoryStore) list(bucket map[string]time.Time, ts time.Time) ([]sentry_store.CallsignTime, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	results := make([]sentry_store.CallsignTime, 0)
+	for callsign, lastSeen := range bucket {
+		if !lastSeen.After(ts) {
+			results = append(results, sentry_store.CallsignTime{callsign, lastSeen})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Callsign < results[j].Callsign
+	})
+	return results, nil
+}
+
+func (store *memoryStore) RemoveLive(callsign string, ts time.Time) error {
+	return store.remove(store.live, callsign, ts)
+}
+
+func (store *memoryStore) RemoveDead(callsign string) error {
+	return store.remove(store.dead, callsign, time.Now())
+}
+
+func (store *memoryStore) remove(bucket map[string]time.Time, callsign string, ts time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if lastSeen, ok := bucket[callsign]; ok && !lastSeen.After(ts) {
+		delete(bucket, callsign)
+	}
+	return nil
+}
+
+func (store *memoryStore) LastSeenLive() (time.Time, error) {
+	return store.lastSeen(store.live)
+}
+
+func (store *memoryStore) LastSeenDead() (time.Time, error) {
+	return store.lastSeen(store.dead)
+}
+
+func (store *memoryStore) lastSeen(bucket map[string]time.Time) (time.Time, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if len(bucket) == 0 {
+		return time.Now(), nil
+	}
+	maxLastSeen := time.Time{}
+	for _, ts := range bucket {
+		if ts.After(maxLastSeen) {
+			maxLastSeen = ts
+		}
+	}
+	return maxLastSeen, nil
+}
+
+func (store *memoryStore) AddEmail(callsign, email string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.emails[callsign] = email
+	return nil
+}
+
+func (store *memoryStore) GetEmail(callsign string) (string, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	email, ok := store.emails[callsign]
+	return email, ok, nil
+}
+
+func (store *memoryStore) ListEmail() ([]sentry_store.CallsignEmail, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	emails := make([]sentry_store.CallsignEmail, 0, len(store.emails))
+	for callsign, email := range store.emails {
+		emails = append(emails, sentry_store.CallsignEmail{callsign, email})
+	}
+	sort.Slice(emails, func(i, j int) bool {
+		return emails[i].Callsign < emails[j].Callsign
+	})
+	return emails, nil
+}
+
+func (store *memoryStore) RemoveEmail(callsign string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.emails, callsign)
+	return nil
+}
+
+func (store *memoryStore) AddCutoff(callsign string, cutoff time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.cutoffs[callsign] = cutoff
+	return nil
+}
+
+func (store *memoryStore) GetCutoff(callsign string) (time.Duration, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	cutoff, ok := store.cutoffs[callsign]
+	return cutoff, ok, nil
+}
+
+func (store *memoryStore) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	cutoffs := make([]sentry_store.CallsignCutoff, 0, len(store.cutoffs))
+	for callsign, cutoff := range store.cutoffs {
+		cutoffs = append(cutoffs, sentry_store.CallsignCutoff{callsign, cutoff})
+	}
+	sort.Slice(cutoffs, func(i, j int) bool {
+		return cutoffs[i].Callsign < cutoffs[j].Callsign
+	})
+	return cutoffs, nil
+}
+
+func (store *memoryStore) RemoveCutoff(callsign string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.cutoffs, callsign)
+	return nil
+}
+
+func (store *memoryStore) AddEvent(event sentry_store.CallsignEvent) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	event.Timestamp = event.Timestamp.UTC()
+	events := append(store.history[event.Callsign], event)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	store.history[event.Callsign] = events
+	return nil
+}
+
+func (store *memoryStore) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	events := make([]sentry_store.CallsignEvent, 0)
+	for _, event := range store.history[callsign] {
+		if !event.Timestamp.Before(since) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (store *memoryStore) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	callsigns := make([]string, 0, len(store.history))
+	for callsign := range store.history {
+		callsigns = append(callsigns, callsign)
+	}
+	sort.Strings(callsigns)
+	events := make([]sentry_store.CallsignEvent, 0)
+	for _, callsign := range callsigns {
+		events = append(events, store.history[callsign]...)
+	}
+	return events, nil
+}
+
+func (store *memoryStore) RemoveEvents(callsign string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.history, callsign)
+	return nil
+}
+
+func (store *memoryStore) AddNode(node sentry_store.NodeInfo) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	node.Updated = node.Updated.UTC()
+	node.Path = append([]string(nil), node.Path...)
+	store.nodes[node.Callsign] = node
+	return nil
+}
+
+func (store *memoryStore) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	node, ok := store.nodes[callsign]
+	return node, ok, nil
+}
+
+func (store *memoryStore) ListNodes() ([]sentry_store.NodeInfo, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	nodes := make([]sentry_store.NodeInfo, 0, len(store.nodes))
+	for _, node := range store.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Callsign < nodes[j].Callsign
+	})
+	return nodes, nil
+}
+
+func (store *memoryStore) RemoveNode(callsign string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.nodes, callsign)
+	return nil
+}
+
+func (store *memoryStore) AddToken(token sentry_store.ApiToken) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	token.Created = token.Created.UTC()
+	store.tokens[token.Name] = token
+	return nil
+}
+
+func (store *memoryStore) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	token, ok := store.tokens[name]
+	return token, ok, nil
+}
+
+func (store *memoryStore) ListTokens() ([]sentry_store.ApiToken, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	tokens := make([]sentry_store.ApiToken, 0, len(store.tokens))
+	for _, token := range store.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+	return tokens, nil
+}
+
+func (store *memoryStore) RemoveToken(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.tokens, name)
+	return nil
+}
+
+func (store *memoryStore) AddAudit(entry sentry_store.AuditEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry.Timestamp = entry.Timestamp.UTC()
+	audit := append(store.audit, entry)
+	sort.SliceStable(audit, func(i, j int) bool {
+		return audit[i].Timestamp.Before(audit[j].Timestamp)
+	})
+	store.audit = audit
+	return nil
+}
+
+func (store *memoryStore) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	entries := make([]sentry_store.AuditEntry, 0)
+	for _, entry := range store.audit {
+		if !entry.Timestamp.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
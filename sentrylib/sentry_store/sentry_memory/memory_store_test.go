@@ -0,0 +1,11 @@
+package sentry_memory_test
+
+import (
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_storetest"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	sentry_storetest.TestStore(t, sentry_memory.NewMemoryStore())
+}
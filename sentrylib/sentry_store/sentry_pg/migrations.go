@@ -0,0 +1,164 @@
+package sentry_pg
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations holds the schema, one entry per version: migrations[0] brings an
+// empty database to version 1 and so on. Never edit a released migration;
+// append a new one instead.
+//
+// Version 1 uses IF NOT EXISTS so databases created by hand before
+// migrations existed are adopted as-is.
+var migrations = []string{
+	// 1: live, dead and emails
+	`CREATE TABLE IF NOT EXISTS live (
+		callsign text PRIMARY KEY,
+		ts timestamptz NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS dead (
+		callsign text PRIMARY KEY,
+		ts timestamptz NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS emails (
+		callsign text PRIMARY KEY,
+		email text NOT NULL
+	);`,
+
+	// 2: up/down history
+	`CREATE TABLE IF NOT EXISTS history (
+		callsign text NOT NULL,
+		event text NOT NULL,
+		ts timestamptz NOT NULL,
+		outage bigint NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS history_callsign_ts ON history (callsign, ts);`,
+
+	// 3: per-callsign cutoffs
+	`CREATE TABLE IF NOT EXISTS cutoffs (
+		callsign text PRIMARY KEY,
+		cutoff bigint NOT NULL
+	);`,
+
+	// 4: node metadata from position reports; path is comma separated
+	`CREATE TABLE nodes (
+		callsign text PRIMARY KEY,
+		updated timestamptz NOT NULL,
+		latitude double precision NOT NULL,
+		longitude double precision NOT NULL,
+		altitude double precision,
+		symbol_table text NOT NULL,
+		symbol_code text NOT NULL,
+		comment text NOT NULL,
+		path text NOT NULL,
+		igate text NOT NULL
+	);`,
+
+	// 5: admin API tokens and the audit log
+	`CREATE TABLE tokens (
+		name text PRIMARY KEY,
+		hash text NOT NULL,
+		scope text NOT NULL,
+		created timestamptz NOT NULL
+	);
+	CREATE TABLE audit (
+		id bigserial PRIMARY KEY,
+		ts timestamptz NOT NULL,
+		actor text NOT NULL,
+		action text NOT NULL,
+		target text NOT NULL,
+		detail text NOT NULL
+	);
+	CREATE INDEX audit_ts ON audit (ts);`,
+}
+
+// migrationLock is the pg_advisory_xact_lock key that keeps two sentry
+// processes from migrating the same database at once.
+const migrationLock = 0x73656e747279
+
+// SchemaVersion is the schema version this build expects.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// Migrate applies any pending migrations to the database at connString and
+// returns the schema version before and after.
+func Migrate(connString string) (int, int, error) {
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	return migrate(db)
+}
+
+func migrate(db *sql.DB) (int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version integer PRIMARY KEY,
+		applied timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	from, err := currentVersion(tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from > len(migrations) {
+		return from, from, fmt.Errorf("Database schema version %d is newer than this build supports (%d)", from, len(migrations))
+	}
+
+	for version := from + 1; version <= len(migrations); version++ {
+		if _, err := tx.Exec(migrations[version-1]); err != nil {
+			return from, from, fmt.Errorf("Migration %d failed: %s", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES ($1)", version); err != nil {
+			return from, from, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return from, from, err
+	}
+	return from, len(migrations), nil
+}
+
+// checkVersion fails unless the schema is exactly the version this build
+// expects. Used when automatic migration is disabled.
+func checkVersion(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass('schema_version') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return err
+	}
+	version := 0
+	if exists {
+		version, err = currentVersion(db)
+		if err != nil {
+			return err
+		}
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("Database schema is at version %d, expected %d; run sentry db migrate", version, len(migrations))
+	}
+	return nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func currentVersion(db queryRower) (int, error) {
+	version := 0
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
@@ -2,6 +2,7 @@ package sentry_pg
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
@@ -12,11 +13,35 @@ type postgresDBStore struct {
 	db *sql.DB
 }
 
+// NewPostgresDB connects to the database and applies any pending schema
+// migrations.
 func NewPostgresDB(connString string) (sentry_store.Store, error) {
 	db, err := sql.Open("postgres", connString)
 	if err != nil {
 		return nil, err
 	}
+	if _, _, err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &postgresDBStore{
+		db: db,
+	}, nil
+}
+
+// OpenPostgresDB connects to the database without changing its schema and
+// fails if the schema is not at SchemaVersion. Use it when operators run
+// Migrate themselves.
+func OpenPostgresDB(connString string) (sentry_store.Store, error) {
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(db); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return &postgresDBStore{
 		db: db,
@@ -27,6 +52,10 @@ func (store *postgresDBStore) AddLive(callsign string) error {
 	return store.add("live", callsign, time.Now())
 }
 
+func (store *postgresDBStore) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add("live", callsign, ts)
+}
+
 func (store *postgresDBStore) AddDead(callsign string, ts time.Time) error {
 	return store.add("dead", callsign, ts)
 }
@@ -178,3 +207,261 @@ func (store *postgresDBStore) RemoveEmail(callsign string) error {
 	_, err := store.db.Exec("DELETE FROM emails WHERE callsign = $1", callsign)
 	return err
 }
+
+func (store *postgresDBStore) AddCutoff(callsign string, cutoff time.Duration) error {
+	_, err := store.db.Exec("INSERT INTO cutoffs (callsign, cutoff) VALUES ($1, $2) ON CONFLICT (callsign) DO UPDATE SET callsign=$1, cutoff=$2", callsign, int64(cutoff))
+	return err
+}
+
+func (store *postgresDBStore) GetCutoff(callsign string) (time.Duration, bool, error) {
+	res := store.db.QueryRow("SELECT cutoff FROM cutoffs WHERE callsign = $1", callsign)
+	cutoff := int64(0)
+	err := res.Scan(&cutoff)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(cutoff), true, nil
+}
+
+func (store *postgresDBStore) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	rows, err := store.db.Query("SELECT callsign, cutoff FROM cutoffs ORDER BY callsign")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ccs := make([]sentry_store.CallsignCutoff, 0)
+	for rows.Next() {
+		callsign := ""
+		cutoff := int64(0)
+		if err := rows.Scan(&callsign, &cutoff); err != nil {
+			return nil, err
+		}
+		ccs = append(ccs, sentry_store.CallsignCutoff{callsign, time.Duration(cutoff)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ccs, nil
+}
+
+func (store *postgresDBStore) RemoveCutoff(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM cutoffs WHERE callsign = $1", callsign)
+	return err
+}
+
+func (store *postgresDBStore) AddEvent(event sentry_store.CallsignEvent) error {
+	_, err := store.db.Exec("INSERT INTO history (callsign, event, ts, outage) VALUES ($1, $2, $3, $4)", event.Callsign, string(event.Event), event.Timestamp.UTC(), int64(event.Outage))
+	return err
+}
+
+func (store *postgresDBStore) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	rows, err := store.db.Query("SELECT event, ts, outage FROM history WHERE callsign = $1 AND ts >= $2 ORDER BY ts", callsign, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]sentry_store.CallsignEvent, 0)
+	for rows.Next() {
+		event := ""
+		ts := time.Time{}
+		outage := int64(0)
+		if err := rows.Scan(&event, &ts, &outage); err != nil {
+			return nil, err
+		}
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  callsign,
+			Event:     sentry_store.EventType(event),
+			Timestamp: ts,
+			Outage:    time.Duration(outage),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *postgresDBStore) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	rows, err := store.db.Query("SELECT callsign, event, ts, outage FROM history ORDER BY callsign, ts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]sentry_store.CallsignEvent, 0)
+	for rows.Next() {
+		callsign := ""
+		event := ""
+		ts := time.Time{}
+		outage := int64(0)
+		if err := rows.Scan(&callsign, &event, &ts, &outage); err != nil {
+			return nil, err
+		}
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  callsign,
+			Event:     sentry_store.EventType(event),
+			Timestamp: ts,
+			Outage:    time.Duration(outage),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *postgresDBStore) RemoveEvents(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM history WHERE callsign = $1", callsign)
+	return err
+}
+
+func (store *postgresDBStore) AddNode(node sentry_store.NodeInfo) error {
+	_, err := store.db.Exec(`INSERT INTO nodes (callsign, updated, latitude, longitude, altitude, symbol_table, symbol_code, comment, path, igate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (callsign) DO UPDATE SET updated = $2, latitude = $3, longitude = $4, altitude = $5,
+		symbol_table = $6, symbol_code = $7, comment = $8, path = $9, igate = $10`,
+		node.Callsign, node.Updated.UTC(), node.Latitude, node.Longitude, nullFloat(node.Altitude),
+		node.SymbolTable, node.SymbolCode, node.Comment, strings.Join(node.Path, ","), node.Igate)
+	return err
+}
+
+func (store *postgresDBStore) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	rows, err := store.db.Query("SELECT "+nodeColumns+" FROM nodes WHERE callsign = $1", callsign)
+	if err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	nodes, err := scanNodes(rows)
+	if err != nil || len(nodes) == 0 {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	return nodes[0], true, nil
+}
+
+func (store *postgresDBStore) ListNodes() ([]sentry_store.NodeInfo, error) {
+	rows, err := store.db.Query("SELECT " + nodeColumns + " FROM nodes ORDER BY callsign")
+	if err != nil {
+		return nil, err
+	}
+	return scanNodes(rows)
+}
+
+func (store *postgresDBStore) RemoveNode(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM nodes WHERE callsign = $1", callsign)
+	return err
+}
+
+const nodeColumns = "callsign, updated, latitude, longitude, altitude, symbol_table, symbol_code, comment, path, igate"
+
+func scanNodes(rows *sql.Rows) ([]sentry_store.NodeInfo, error) {
+	defer rows.Close()
+	nodes := make([]sentry_store.NodeInfo, 0)
+	for rows.Next() {
+		node := sentry_store.NodeInfo{}
+		altitude := sql.NullFloat64{}
+		path := ""
+		err := rows.Scan(&node.Callsign, &node.Updated, &node.Latitude, &node.Longitude, &altitude,
+			&node.SymbolTable, &node.SymbolCode, &node.Comment, &path, &node.Igate)
+		if err != nil {
+			return nil, err
+		}
+		if altitude.Valid {
+			node.Altitude = &altitude.Float64
+		}
+		if path != "" {
+			node.Path = strings.Split(path, ",")
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func nullFloat(f *float64) sql.NullFloat64 {
+	if f == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *f, Valid: true}
+}
+
+func (store *postgresDBStore) AddToken(token sentry_store.ApiToken) error {
+	_, err := store.db.Exec(`INSERT INTO tokens (name, hash, scope, created) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET hash = $2, scope = $3, created = $4`,
+		token.Name, token.Hash, string(token.Scope), token.Created.UTC())
+	return err
+}
+
+func (store *postgresDBStore) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	rows, err := store.db.Query("SELECT name, hash, scope, created FROM tokens WHERE name = $1", name)
+	if err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil || len(tokens) == 0 {
+		return sentry_store.ApiToken{}, false, err
+	}
+	return tokens[0], true, nil
+}
+
+func (store *postgresDBStore) ListTokens() ([]sentry_store.ApiToken, error) {
+	rows, err := store.db.Query("SELECT name, hash, scope, created FROM tokens ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (store *postgresDBStore) RemoveToken(name string) error {
+	_, err := store.db.Exec("DELETE FROM tokens WHERE name = $1", name)
+	return err
+}
+
+func scanTokens(rows *sql.Rows) ([]sentry_store.ApiToken, error) {
+	defer rows.Close()
+	tokens := make([]sentry_store.ApiToken, 0)
+	for rows.Next() {
+		token := sentry_store.ApiToken{}
+		scope := ""
+		if err := rows.Scan(&token.Name, &token.Hash, &scope, &token.Created); err != nil {
+			return nil, err
+		}
+		token.Scope = sentry_store.TokenScope(scope)
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (store *postgresDBStore) AddAudit(entry sentry_store.AuditEntry) error {
+	_, err := store.db.Exec("INSERT INTO audit (ts, actor, action, target, detail) VALUES ($1, $2, $3, $4, $5)",
+		entry.Timestamp.UTC(), entry.Actor, entry.Action, entry.Target, entry.Detail)
+	return err
+}
+
+func (store *postgresDBStore) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	rows, err := store.db.Query("SELECT ts, actor, action, target, detail FROM audit WHERE ts >= $1 ORDER BY ts, id", since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]sentry_store.AuditEntry, 0)
+	for rows.Next() {
+		entry := sentry_store.AuditEntry{}
+		if err := rows.Scan(&entry.Timestamp, &entry.Actor, &entry.Action, &entry.Target, &entry.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (store *postgresDBStore) Close() error {
+	return store.db.Close()
+}
@@ -0,0 +1,24 @@
+package sentry_pg_test
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_pg"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_storetest"
+	"os"
+	"testing"
+)
+
+// TestPostgresDB needs a scratch database, e.g.
+// SENTRY_TEST_POSTGRES="user=sentry dbname=sentry_test sslmode=disable"
+func TestPostgresDB(t *testing.T) {
+	connString := os.Getenv("SENTRY_TEST_POSTGRES")
+	if connString == "" {
+		t.Skip("SENTRY_TEST_POSTGRES not set")
+	}
+
+	store, err := sentry_pg.NewPostgresDB(connString)
+	assert.NilError(t, err)
+	defer store.Close()
+
+	sentry_storetest.TestStore(t, store)
+}
@@ -17,39 +17,94 @@ type rethinkEntry struct {
 	Id       string    `gorethink:"id,omitempty"`
 }
 
+type rethinkCutoff struct {
+	Callsign string `gorethink:"callsign"`
+	Cutoff   int64  `gorethink:"cutoff"`
+	Id       string `gorethink:"id,omitempty"`
+}
+
+type rethinkEvent struct {
+	Callsign  string    `gorethink:"callsign"`
+	Event     string    `gorethink:"event"`
+	Timestamp time.Time `gorethink:"timestamp"`
+	Outage    int64     `gorethink:"outage"`
+	Id        string    `gorethink:"id,omitempty"`
+}
+
+// rethinkNode is keyed by callsign so AddNode can replace in place.
+type rethinkNode struct {
+	Id          string    `gorethink:"id"`
+	Callsign    string    `gorethink:"callsign"`
+	Updated     time.Time `gorethink:"updated"`
+	Latitude    float64   `gorethink:"latitude"`
+	Longitude   float64   `gorethink:"longitude"`
+	Altitude    *float64  `gorethink:"altitude,omitempty"`
+	SymbolTable string    `gorethink:"symbol_table"`
+	SymbolCode  string    `gorethink:"symbol_code"`
+	Comment     string    `gorethink:"comment"`
+	Path        []string  `gorethink:"path"`
+	Igate       string    `gorethink:"igate"`
+}
+
+func (entry rethinkNode) nodeInfo() sentry_store.NodeInfo {
+	return sentry_store.NodeInfo{
+		Callsign:    entry.Callsign,
+		Updated:     entry.Updated,
+		Latitude:    entry.Latitude,
+		Longitude:   entry.Longitude,
+		Altitude:    entry.Altitude,
+		SymbolTable: entry.SymbolTable,
+		SymbolCode:  entry.SymbolCode,
+		Comment:     entry.Comment,
+		Path:        entry.Path,
+		Igate:       entry.Igate,
+	}
+}
+
+// rethinkToken is keyed by name so AddToken can replace in place.
+type rethinkToken struct {
+	Id      string    `gorethink:"id"`
+	Hash    string    `gorethink:"hash"`
+	Scope   string    `gorethink:"scope"`
+	Created time.Time `gorethink:"created"`
+}
+
+func (entry rethinkToken) apiToken() sentry_store.ApiToken {
+	return sentry_store.ApiToken{
+		Name:    entry.Id,
+		Hash:    entry.Hash,
+		Scope:   sentry_store.TokenScope(entry.Scope),
+		Created: entry.Created,
+	}
+}
+
+type rethinkAudit struct {
+	Timestamp time.Time `gorethink:"ts"`
+	Actor     string    `gorethink:"actor"`
+	Action    string    `gorethink:"action"`
+	Target    string    `gorethink:"target"`
+	Detail    string    `gorethink:"detail"`
+	Id        string    `gorethink:"id,omitempty"`
+}
+
 type rethinkEmail struct {
 	Callsign string `gorethink:"callsign"`
 	Email    string `gorethink:"email"`
 	Id       string `gorethink:"id,omitempty"`
 }
 
+// NewRethinkDB connects to RethinkDB and creates the database, tables and
+// indexes that do not exist yet. Existing data is kept.
 func NewRethinkDB(opts r.ConnectOpts, db string) (sentry_store.Store, error) {
 	session, err := r.Connect(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	r.DBDrop(db).Exec(session)
-	r.DBCreate(db).Exec(session)
-
-	r.DB(db).TableDrop("live").Exec(session)
-	r.DB(db).TableDrop("dead").Exec(session)
-	r.DB(db).TableDrop("email").Exec(session)
-
-	r.DB(db).TableCreate("live").Exec(session)
-	r.DB(db).TableCreate("dead").Exec(session)
-	r.DB(db).TableCreate("email").Exec(session)
-
-	r.DB(db).Table("live").IndexCreate("callsign").Exec(session)
-	r.DB(db).Table("live").IndexCreate("lastseen").Exec(session)
-	r.DB(db).Table("live").IndexWait().Exec(session)
-
-	r.DB(db).Table("dead").IndexCreate("callsign").Exec(session)
-	r.DB(db).Table("dead").IndexCreate("lastseen").Exec(session)
-	r.DB(db).Table("dead").IndexWait().Exec(session)
-
-	r.DB(db).Table("email").IndexCreate("callsign").Exec(session)
-	r.DB(db).Table("email").IndexWait().Exec(session)
+	if err := ensureSchema(session, db); err != nil {
+		session.Close()
+		return nil, err
+	}
 
 	store := &rethinkDBStore{
 		session: session,
@@ -63,6 +118,10 @@ func (store *rethinkDBStore) AddLive(callsign string) error {
 	return store.add("live", callsign, time.Now())
 }
 
+func (store *rethinkDBStore) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add("live", callsign, ts)
+}
+
 func (store *rethinkDBStore) AddDead(callsign string, ts time.Time) error {
 	return store.add("dead", callsign, ts)
 }
@@ -273,3 +332,282 @@ func (store *rethinkDBStore) RemoveEmail(callsign string) error {
 	}
 	return nil
 }
+
+func (store *rethinkDBStore) AddCutoff(callsign string, cutoff time.Duration) error {
+	m, _, err := store.getCutoffByIndex(callsign)
+	m.Callsign = callsign
+	m.Cutoff = int64(cutoff)
+	err = r.DB(store.db).Table("cutoff").Insert(m, r.InsertOpts{Conflict: "replace"}).Exec(store.session)
+	return err
+}
+
+func (store *rethinkDBStore) getCutoffByIndex(callsign string) (rethinkCutoff, bool, error) {
+	res, err := r.DB(store.db).Table("cutoff").GetAllByIndex("callsign", callsign).Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return rethinkCutoff{}, false, err
+	}
+	if res.IsNil() {
+		return rethinkCutoff{}, false, nil
+	}
+	m := rethinkCutoff{}
+	err = res.One(&m)
+	return m, true, err
+}
+
+func (store *rethinkDBStore) GetCutoff(callsign string) (time.Duration, bool, error) {
+	res, ok, err := store.getCutoffByIndex(callsign)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		return 0, false, nil
+	}
+	return time.Duration(res.Cutoff), true, nil
+}
+
+func (store *rethinkDBStore) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	res, err := r.DB(store.db).Table("cutoff").OrderBy("callsign").Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	filteredRows := make([]sentry_store.CallsignCutoff, 0)
+	if res.IsNil() {
+		return filteredRows, nil
+	}
+	var entry rethinkCutoff
+	for res.Next(&entry) {
+		filteredRows = append(filteredRows, sentry_store.CallsignCutoff{entry.Callsign, time.Duration(entry.Cutoff)})
+	}
+	return filteredRows, nil
+}
+
+func (store *rethinkDBStore) RemoveCutoff(callsign string) error {
+	m, ok, err := store.getCutoffByIndex(callsign)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return r.DB(store.db).Table("cutoff").Get(m.Id).Delete(r.DeleteOpts{}).Exec(store.session)
+	}
+	return nil
+}
+
+func (store *rethinkDBStore) AddEvent(event sentry_store.CallsignEvent) error {
+	m := rethinkEvent{
+		Callsign:  event.Callsign,
+		Event:     string(event.Event),
+		Timestamp: event.Timestamp,
+		Outage:    int64(event.Outage),
+	}
+	return r.DB(store.db).Table("history").Insert(m).Exec(store.session)
+}
+
+func (store *rethinkDBStore) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	res, err := r.DB(store.db).Table("history").GetAllByIndex("callsign", callsign).Filter(r.Row.Field("timestamp").Ge(since)).OrderBy("timestamp").Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := make([]sentry_store.CallsignEvent, 0)
+	if res.IsNil() {
+		return events, nil
+	}
+	var entry rethinkEvent
+	for res.Next(&entry) {
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  entry.Callsign,
+			Event:     sentry_store.EventType(entry.Event),
+			Timestamp: entry.Timestamp,
+			Outage:    time.Duration(entry.Outage),
+		})
+	}
+	return events, res.Err()
+}
+
+func (store *rethinkDBStore) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	res, err := r.DB(store.db).Table("history").OrderBy("callsign", "timestamp").Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := make([]sentry_store.CallsignEvent, 0)
+	if res.IsNil() {
+		return events, nil
+	}
+	var entry rethinkEvent
+	for res.Next(&entry) {
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  entry.Callsign,
+			Event:     sentry_store.EventType(entry.Event),
+			Timestamp: entry.Timestamp,
+			Outage:    time.Duration(entry.Outage),
+		})
+	}
+	return events, res.Err()
+}
+
+func (store *rethinkDBStore) RemoveEvents(callsign string) error {
+	return r.DB(store.db).Table("history").GetAllByIndex("callsign", callsign).Delete(r.DeleteOpts{}).Exec(store.session)
+}
+
+func (store *rethinkDBStore) AddNode(node sentry_store.NodeInfo) error {
+	entry := rethinkNode{
+		Id:          node.Callsign,
+		Callsign:    node.Callsign,
+		Updated:     node.Updated,
+		Latitude:    node.Latitude,
+		Longitude:   node.Longitude,
+		Altitude:    node.Altitude,
+		SymbolTable: node.SymbolTable,
+		SymbolCode:  node.SymbolCode,
+		Comment:     node.Comment,
+		Path:        node.Path,
+		Igate:       node.Igate,
+	}
+	return r.DB(store.db).Table("node").Insert(entry, r.InsertOpts{Conflict: "replace"}).Exec(store.session)
+}
+
+func (store *rethinkDBStore) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	res, err := r.DB(store.db).Table("node").Get(callsign).Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	if res.IsNil() {
+		return sentry_store.NodeInfo{}, false, nil
+	}
+	var entry rethinkNode
+	if err := res.One(&entry); err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	return entry.nodeInfo(), true, nil
+}
+
+func (store *rethinkDBStore) ListNodes() ([]sentry_store.NodeInfo, error) {
+	res, err := r.DB(store.db).Table("node").OrderBy("callsign").Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]sentry_store.NodeInfo, 0)
+	if res.IsNil() {
+		return nodes, nil
+	}
+	var entry rethinkNode
+	for res.Next(&entry) {
+		nodes = append(nodes, entry.nodeInfo())
+		entry = rethinkNode{}
+	}
+	return nodes, res.Err()
+}
+
+func (store *rethinkDBStore) RemoveNode(callsign string) error {
+	return r.DB(store.db).Table("node").Get(callsign).Delete().Exec(store.session)
+}
+
+func (store *rethinkDBStore) AddToken(token sentry_store.ApiToken) error {
+	entry := rethinkToken{
+		Id:      token.Name,
+		Hash:    token.Hash,
+		Scope:   string(token.Scope),
+		Created: token.Created,
+	}
+	return r.DB(store.db).Table("token").Insert(entry, r.InsertOpts{Conflict: "replace"}).Exec(store.session)
+}
+
+func (store *rethinkDBStore) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	res, err := r.DB(store.db).Table("token").Get(name).Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	if res.IsNil() {
+		return sentry_store.ApiToken{}, false, nil
+	}
+	var entry rethinkToken
+	if err := res.One(&entry); err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	return entry.apiToken(), true, nil
+}
+
+func (store *rethinkDBStore) ListTokens() ([]sentry_store.ApiToken, error) {
+	res, err := r.DB(store.db).Table("token").OrderBy("id").Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]sentry_store.ApiToken, 0)
+	if res.IsNil() {
+		return tokens, nil
+	}
+	var entry rethinkToken
+	for res.Next(&entry) {
+		tokens = append(tokens, entry.apiToken())
+		entry = rethinkToken{}
+	}
+	return tokens, res.Err()
+}
+
+func (store *rethinkDBStore) RemoveToken(name string) error {
+	return r.DB(store.db).Table("token").Get(name).Delete().Exec(store.session)
+}
+
+func (store *rethinkDBStore) AddAudit(entry sentry_store.AuditEntry) error {
+	m := rethinkAudit{
+		Timestamp: entry.Timestamp,
+		Actor:     entry.Actor,
+		Action:    entry.Action,
+		Target:    entry.Target,
+		Detail:    entry.Detail,
+	}
+	return r.DB(store.db).Table("audit").Insert(m).Exec(store.session)
+}
+
+func (store *rethinkDBStore) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	res, err := r.DB(store.db).Table("audit").Between(since, r.MaxVal, r.BetweenOpts{Index: "ts"}).OrderBy(r.OrderByOpts{Index: "ts"}).Run(store.session)
+	if res != nil {
+		defer res.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]sentry_store.AuditEntry, 0)
+	if res.IsNil() {
+		return entries, nil
+	}
+	var entry rethinkAudit
+	for res.Next(&entry) {
+		entries = append(entries, sentry_store.AuditEntry{
+			Timestamp: entry.Timestamp,
+			Actor:     entry.Actor,
+			Action:    entry.Action,
+			Target:    entry.Target,
+			Detail:    entry.Detail,
+		})
+		entry = rethinkAudit{}
+	}
+	return entries, res.Err()
+}
+
+func (store *rethinkDBStore) Close() error {
+	return store.session.Close()
+}
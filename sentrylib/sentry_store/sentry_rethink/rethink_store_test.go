@@ -0,0 +1,25 @@
+package sentry_rethink_test
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_rethink"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_storetest"
+	"gopkg.in/gorethink/gorethink.v3"
+	"os"
+	"testing"
+)
+
+// TestRethinkDB needs a RethinkDB server, e.g.
+// SENTRY_TEST_RETHINKDB=localhost:28015. It uses the "test" database.
+func TestRethinkDB(t *testing.T) {
+	address := os.Getenv("SENTRY_TEST_RETHINKDB")
+	if address == "" {
+		t.Skip("SENTRY_TEST_RETHINKDB not set")
+	}
+
+	store, err := sentry_rethink.NewRethinkDB(gorethink.ConnectOpts{Address: address}, "test")
+	assert.NilError(t, err)
+	defer store.Close()
+
+	sentry_storetest.TestStore(t, store)
+}
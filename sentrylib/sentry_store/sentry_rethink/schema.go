@@ -0,0 +1,129 @@
+package sentry_rethink
+
+import (
+	"fmt"
+
+	r "gopkg.in/gorethink/gorethink.v3"
+)
+
+// schemaVersion is bumped whenever tables or indexes change in a way that
+// older builds cannot work with. It is stored in the schema table.
+const schemaVersion = 1
+
+// tables lists every table the store uses with the secondary indexes it
+// queries by.
+var tables = []struct {
+	name    string
+	indexes []string
+}{
+	{"schema", nil},
+	{"live", []string{"callsign", "lastseen"}},
+	{"dead", []string{"callsign", "lastseen"}},
+	{"email", []string{"callsign"}},
+	{"history", []string{"callsign"}},
+	{"cutoff", []string{"callsign"}},
+	{"node", []string{"callsign"}},
+	{"token", nil},
+	{"audit", []string{"ts"}},
+}
+
+type rethinkSchema struct {
+	Id      string `gorethink:"id"`
+	Version int    `gorethink:"version"`
+}
+
+// ensureSchema creates the database, tables and indexes that are missing and
+// leaves existing data alone. It fails if the database was written by a newer
+// build.
+func ensureSchema(session *r.Session, db string) error {
+	dbs, err := listStrings(session, r.DBList())
+	if err != nil {
+		return err
+	}
+	if !contains(dbs, db) {
+		if err := r.DBCreate(db).Exec(session); err != nil {
+			return err
+		}
+	}
+
+	existing, err := listStrings(session, r.DB(db).TableList())
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if !contains(existing, table.name) {
+			if err := r.DB(db).TableCreate(table.name).Exec(session); err != nil {
+				return err
+			}
+		}
+		if err := ensureIndexes(session, db, table.name, table.indexes); err != nil {
+			return err
+		}
+	}
+
+	return checkVersion(session, db)
+}
+
+// ensureIndexes creates any missing index on table and waits until all of
+// them are ready to serve queries.
+func ensureIndexes(session *r.Session, db, table string, indexes []string) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	existing, err := listStrings(session, r.DB(db).Table(table).IndexList())
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if contains(existing, index) {
+			continue
+		}
+		if err := r.DB(db).Table(table).IndexCreate(index).Exec(session); err != nil {
+			return err
+		}
+	}
+	return r.DB(db).Table(table).IndexWait().Exec(session)
+}
+
+// checkVersion records schemaVersion on first start, including databases
+// created before versions were tracked, and refuses to run against a newer
+// schema.
+func checkVersion(session *r.Session, db string) error {
+	res, err := r.DB(db).Table("schema").Get("version").Run(session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	stored := rethinkSchema{}
+	err = res.One(&stored)
+	if err != nil && err != r.ErrEmptyResult {
+		return err
+	}
+	if stored.Version > schemaVersion {
+		return fmt.Errorf("Database schema version %d is newer than this build supports (%d)", stored.Version, schemaVersion)
+	}
+	if stored.Version == schemaVersion {
+		return nil
+	}
+	return r.DB(db).Table("schema").Insert(rethinkSchema{"version", schemaVersion}, r.InsertOpts{Conflict: "replace"}).Exec(session)
+}
+
+func listStrings(session *r.Session, term r.Term) ([]string, error) {
+	res, err := term.Run(session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	values := make([]string, 0)
+	err = res.All(&values)
+	return values, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
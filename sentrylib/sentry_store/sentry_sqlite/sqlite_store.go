@@ -0,0 +1,510 @@
+// Package sentry_sqlite stores everything in a single SQLite file through
+// github.com/mattn/go-sqlite3. That driver is cgo, so building sentry needs
+// CGO_ENABLED=1 and a C compiler; with cgo disabled the driver still compiles
+// but every Open fails at runtime.
+package sentry_sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Timestamps are stored as fixed-width UTC text so they sort correctly and
+// stay readable when querying the file with the sqlite3 shell.
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS live (callsign TEXT PRIMARY KEY, ts TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS dead (callsign TEXT PRIMARY KEY, ts TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS emails (callsign TEXT PRIMARY KEY, email TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS cutoffs (callsign TEXT PRIMARY KEY, cutoff INTEGER NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS history (callsign TEXT NOT NULL, event TEXT NOT NULL, ts TEXT NOT NULL, outage INTEGER NOT NULL DEFAULT 0)",
+	"CREATE INDEX IF NOT EXISTS history_callsign_ts ON history (callsign, ts)",
+	"CREATE TABLE IF NOT EXISTS nodes (callsign TEXT PRIMARY KEY, updated TEXT NOT NULL, latitude REAL NOT NULL, longitude REAL NOT NULL, altitude REAL, symbol_table TEXT NOT NULL, symbol_code TEXT NOT NULL, comment TEXT NOT NULL, path TEXT NOT NULL, igate TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS tokens (name TEXT PRIMARY KEY, hash TEXT NOT NULL, scope TEXT NOT NULL, created TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS audit (id INTEGER PRIMARY KEY AUTOINCREMENT, ts TEXT NOT NULL, actor TEXT NOT NULL, action TEXT NOT NULL, target TEXT NOT NULL, detail TEXT NOT NULL)",
+	"CREATE INDEX IF NOT EXISTS audit_ts ON audit (ts)",
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// NewSqliteStore opens or creates the database file f and creates any
+// missing tables.
+func NewSqliteStore(f string) (sentry_store.Store, error) {
+	db, err := sql.Open("sqlite3", f+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows one writer; serializing here avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &sqliteStore{
+		db: db,
+	}, nil
+}
+
+func formatTime(ts time.Time) string {
+	return ts.UTC().Format(timeFormat)
+}
+
+func parseTime(ts string) (time.Time, error) {
+	return time.Parse(timeFormat, ts)
+}
+
+func (store *sqliteStore) AddLive(callsign string) error {
+	return store.add("live", callsign, time.Now())
+}
+
+func (store *sqliteStore) AddLiveAt(callsign string, ts time.Time) error {
+	return store.add("live", callsign, ts)
+}
+
+func (store *sqliteStore) AddDead(callsign string, ts time.Time) error {
+	return store.add("dead", callsign, ts)
+}
+
+func (store *sqliteStore) add(table, callsign string, ts time.Time) error {
+	_, err := store.db.Exec("INSERT OR REPLACE INTO "+table+" (callsign, ts) VALUES (?, ?)", callsign, formatTime(ts))
+	return err
+}
+
+func (store *sqliteStore) CountLive() (int, error) {
+	return store.count("live")
+}
+
+func (store *sqliteStore) CountDead() (int, error) {
+	return store.count("dead")
+}
+
+func (store *sqliteStore) count(table string) (int, error) {
+	count := 0
+	err := store.db.QueryRow("SELECT count(*) FROM " + table).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (store *sqliteStore) GetLive(callsign string) (time.Time, bool, error) {
+	return store.get("live", callsign)
+}
+
+func (store *sqliteStore) GetDead(callsign string) (time.Time, bool, error) {
+	return store.get("dead", callsign)
+}
+
+func (store *sqliteStore) get(table, callsign string) (time.Time, bool, error) {
+	ts := ""
+	err := store.db.QueryRow("SELECT ts FROM "+table+" WHERE callsign = ?", callsign).Scan(&ts)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	lastSeen, err := parseTime(ts)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return lastSeen, true, nil
+}
+
+func (store *sqliteStore) ListLive(ts time.Time) ([]sentry_store.CallsignTime, error) {
+	return store.list("live", ts)
+}
+
+func (store *sqliteStore) ListDead() ([]sentry_store.CallsignTime, error) {
+	return store.list("dead", time.Now())
+}
+
+func (store *sqliteStore) list(table string, ts time.Time) ([]sentry_store.CallsignTime, error) {
+	rows, err := store.db.Query("SELECT callsign, ts FROM "+table+" WHERE ts <= ?", formatTime(ts))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cts := make([]sentry_store.CallsignTime, 0)
+	for rows.Next() {
+		callsign := ""
+		lastSeen := ""
+		if err := rows.Scan(&callsign, &lastSeen); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(lastSeen)
+		if err != nil {
+			return nil, err
+		}
+		cts = append(cts, sentry_store.CallsignTime{callsign, parsed})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return cts, nil
+}
+
+func (store *sqliteStore) RemoveLive(callsign string, ts time.Time) error {
+	return store.remove("live", callsign, ts)
+}
+
+func (store *sqliteStore) RemoveDead(callsign string) error {
+	return store.remove("dead", callsign, time.Now())
+}
+
+func (store *sqliteStore) remove(table, callsign string, ts time.Time) error {
+	_, err := store.db.Exec("DELETE FROM "+table+" WHERE callsign = ? AND ts <= ?", callsign, formatTime(ts))
+	return err
+}
+
+func (store *sqliteStore) LastSeenLive() (time.Time, error) {
+	return store.lastSeen("live")
+}
+
+func (store *sqliteStore) LastSeenDead() (time.Time, error) {
+	return store.lastSeen("dead")
+}
+
+// lastSeen returns now for an empty table, matching the bolt and leveldb
+// stores.
+func (store *sqliteStore) lastSeen(table string) (time.Time, error) {
+	ts := ""
+	err := store.db.QueryRow("SELECT ts FROM " + table + " ORDER BY ts DESC LIMIT 1").Scan(&ts)
+	if err == sql.ErrNoRows {
+		return time.Now(), nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(ts)
+}
+
+func (store *sqliteStore) AddEmail(callsign, email string) error {
+	_, err := store.db.Exec("INSERT OR REPLACE INTO emails (callsign, email) VALUES (?, ?)", callsign, email)
+	return err
+}
+
+func (store *sqliteStore) GetEmail(callsign string) (string, bool, error) {
+	email := ""
+	err := store.db.QueryRow("SELECT email FROM emails WHERE callsign = ?", callsign).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return email, true, nil
+}
+
+func (store *sqliteStore) ListEmail() ([]sentry_store.CallsignEmail, error) {
+	rows, err := store.db.Query("SELECT callsign, email FROM emails")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ces := make([]sentry_store.CallsignEmail, 0)
+	for rows.Next() {
+		callsign := ""
+		email := ""
+		if err := rows.Scan(&callsign, &email); err != nil {
+			return nil, err
+		}
+		ces = append(ces, sentry_store.CallsignEmail{callsign, email})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ces, nil
+}
+
+func (store *sqliteStore) RemoveEmail(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM emails WHERE callsign = ?", callsign)
+	return err
+}
+
+func (store *sqliteStore) AddCutoff(callsign string, cutoff time.Duration) error {
+	_, err := store.db.Exec("INSERT OR REPLACE INTO cutoffs (callsign, cutoff) VALUES (?, ?)", callsign, int64(cutoff))
+	return err
+}
+
+func (store *sqliteStore) GetCutoff(callsign string) (time.Duration, bool, error) {
+	cutoff := int64(0)
+	err := store.db.QueryRow("SELECT cutoff FROM cutoffs WHERE callsign = ?", callsign).Scan(&cutoff)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(cutoff), true, nil
+}
+
+func (store *sqliteStore) ListCutoff() ([]sentry_store.CallsignCutoff, error) {
+	rows, err := store.db.Query("SELECT callsign, cutoff FROM cutoffs ORDER BY callsign")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ccs := make([]sentry_store.CallsignCutoff, 0)
+	for rows.Next() {
+		callsign := ""
+		cutoff := int64(0)
+		if err := rows.Scan(&callsign, &cutoff); err != nil {
+			return nil, err
+		}
+		ccs = append(ccs, sentry_store.CallsignCutoff{callsign, time.Duration(cutoff)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ccs, nil
+}
+
+func (store *sqliteStore) RemoveCutoff(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM cutoffs WHERE callsign = ?", callsign)
+	return err
+}
+
+func (store *sqliteStore) AddEvent(event sentry_store.CallsignEvent) error {
+	_, err := store.db.Exec("INSERT INTO history (callsign, event, ts, outage) VALUES (?, ?, ?, ?)", event.Callsign, string(event.Event), formatTime(event.Timestamp), int64(event.Outage))
+	return err
+}
+
+func (store *sqliteStore) ListEvents(callsign string, since time.Time) ([]sentry_store.CallsignEvent, error) {
+	rows, err := store.db.Query("SELECT event, ts, outage FROM history WHERE callsign = ? AND ts >= ? ORDER BY ts", callsign, formatTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]sentry_store.CallsignEvent, 0)
+	for rows.Next() {
+		event := ""
+		ts := ""
+		outage := int64(0)
+		if err := rows.Scan(&event, &ts, &outage); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  callsign,
+			Event:     sentry_store.EventType(event),
+			Timestamp: parsed,
+			Outage:    time.Duration(outage),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *sqliteStore) ListAllEvents() ([]sentry_store.CallsignEvent, error) {
+	rows, err := store.db.Query("SELECT callsign, event, ts, outage FROM history ORDER BY callsign, ts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]sentry_store.CallsignEvent, 0)
+	for rows.Next() {
+		callsign := ""
+		event := ""
+		ts := ""
+		outage := int64(0)
+		if err := rows.Scan(&callsign, &event, &ts, &outage); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, sentry_store.CallsignEvent{
+			Callsign:  callsign,
+			Event:     sentry_store.EventType(event),
+			Timestamp: parsed,
+			Outage:    time.Duration(outage),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (store *sqliteStore) RemoveEvents(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM history WHERE callsign = ?", callsign)
+	return err
+}
+
+func (store *sqliteStore) AddNode(node sentry_store.NodeInfo) error {
+	_, err := store.db.Exec(`INSERT OR REPLACE INTO nodes (callsign, updated, latitude, longitude, altitude, symbol_table, symbol_code, comment, path, igate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		node.Callsign, formatTime(node.Updated), node.Latitude, node.Longitude, nullFloat(node.Altitude),
+		node.SymbolTable, node.SymbolCode, node.Comment, strings.Join(node.Path, ","), node.Igate)
+	return err
+}
+
+func (store *sqliteStore) GetNode(callsign string) (sentry_store.NodeInfo, bool, error) {
+	rows, err := store.db.Query("SELECT "+nodeColumns+" FROM nodes WHERE callsign = ?", callsign)
+	if err != nil {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	nodes, err := scanNodes(rows)
+	if err != nil || len(nodes) == 0 {
+		return sentry_store.NodeInfo{}, false, err
+	}
+	return nodes[0], true, nil
+}
+
+func (store *sqliteStore) ListNodes() ([]sentry_store.NodeInfo, error) {
+	rows, err := store.db.Query("SELECT " + nodeColumns + " FROM nodes ORDER BY callsign")
+	if err != nil {
+		return nil, err
+	}
+	return scanNodes(rows)
+}
+
+func (store *sqliteStore) RemoveNode(callsign string) error {
+	_, err := store.db.Exec("DELETE FROM nodes WHERE callsign = ?", callsign)
+	return err
+}
+
+const nodeColumns = "callsign, updated, latitude, longitude, altitude, symbol_table, symbol_code, comment, path, igate"
+
+func scanNodes(rows *sql.Rows) ([]sentry_store.NodeInfo, error) {
+	defer rows.Close()
+	nodes := make([]sentry_store.NodeInfo, 0)
+	for rows.Next() {
+		node := sentry_store.NodeInfo{}
+		updated := ""
+		altitude := sql.NullFloat64{}
+		path := ""
+		err := rows.Scan(&node.Callsign, &updated, &node.Latitude, &node.Longitude, &altitude,
+			&node.SymbolTable, &node.SymbolCode, &node.Comment, &path, &node.Igate)
+		if err != nil {
+			return nil, err
+		}
+		node.Updated, err = parseTime(updated)
+		if err != nil {
+			return nil, err
+		}
+		if altitude.Valid {
+			node.Altitude = &altitude.Float64
+		}
+		if path != "" {
+			node.Path = strings.Split(path, ",")
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func nullFloat(f *float64) sql.NullFloat64 {
+	if f == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *f, Valid: true}
+}
+
+func (store *sqliteStore) AddToken(token sentry_store.ApiToken) error {
+	_, err := store.db.Exec("INSERT OR REPLACE INTO tokens (name, hash, scope, created) VALUES (?, ?, ?, ?)",
+		token.Name, token.Hash, string(token.Scope), formatTime(token.Created))
+	return err
+}
+
+func (store *sqliteStore) GetToken(name string) (sentry_store.ApiToken, bool, error) {
+	rows, err := store.db.Query("SELECT name, hash, scope, created FROM tokens WHERE name = ?", name)
+	if err != nil {
+		return sentry_store.ApiToken{}, false, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil || len(tokens) == 0 {
+		return sentry_store.ApiToken{}, false, err
+	}
+	return tokens[0], true, nil
+}
+
+func (store *sqliteStore) ListTokens() ([]sentry_store.ApiToken, error) {
+	rows, err := store.db.Query("SELECT name, hash, scope, created FROM tokens ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (store *sqliteStore) RemoveToken(name string) error {
+	_, err := store.db.Exec("DELETE FROM tokens WHERE name = ?", name)
+	return err
+}
+
+func scanTokens(rows *sql.Rows) ([]sentry_store.ApiToken, error) {
+	defer rows.Close()
+	tokens := make([]sentry_store.ApiToken, 0)
+	for rows.Next() {
+		token := sentry_store.ApiToken{}
+		scope := ""
+		created := ""
+		if err := rows.Scan(&token.Name, &token.Hash, &scope, &created); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(created)
+		if err != nil {
+			return nil, err
+		}
+		token.Scope = sentry_store.TokenScope(scope)
+		token.Created = parsed
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (store *sqliteStore) AddAudit(entry sentry_store.AuditEntry) error {
+	_, err := store.db.Exec("INSERT INTO audit (ts, actor, action, target, detail) VALUES (?, ?, ?, ?, ?)",
+		formatTime(entry.Timestamp), entry.Actor, entry.Action, entry.Target, entry.Detail)
+	return err
+}
+
+func (store *sqliteStore) ListAudit(since time.Time) ([]sentry_store.AuditEntry, error) {
+	rows, err := store.db.Query("SELECT ts, actor, action, target, detail FROM audit WHERE ts >= ? ORDER BY ts, id", formatTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]sentry_store.AuditEntry, 0)
+	for rows.Next() {
+		entry := sentry_store.AuditEntry{}
+		ts := ""
+		if err := rows.Scan(&ts, &entry.Actor, &entry.Action, &entry.Target, &entry.Detail); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		entry.Timestamp = parsed
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (store *sqliteStore) Close() error {
+	return store.db.Close()
+}
@@ -0,0 +1,23 @@
+package sentry_sqlite_test
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_sqlite"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_storetest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentry_sqlite")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := sentry_sqlite.NewSqliteStore(filepath.Join(dir, "test.db"))
+	assert.NilError(t, err)
+	defer store.Close()
+
+	sentry_storetest.TestStore(t, store)
+}
@@ -0,0 +1,748 @@
+// Package sentry_storetest is a conformance suite for sentry_store.Store
+// implementations. A backend's tests open a store and hand it to TestStore.
+package sentry_storetest
+
+import (
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"testing"
+	"time"
+)
+
+// TestStore runs every conformance test against storage as a subtest. The
+// tests share callsigns, so storage should not be used by anything else
+// while they run.
+func TestStore(t *testing.T, storage sentry_store.Store) {
+	tests := []struct {
+		name string
+		test func(*testing.T, sentry_store.Store)
+	}{
+		{"AddLiveNew", testAddLiveNew},
+		{"AddLiveExisting", testAddLiveExisting},
+		{"AddLiveAt", testAddLiveAt},
+		{"GetLiveNoKey", testGetLiveNoKey},
+		{"RemoveLive", testRemoveLive},
+		{"ListLive", testListLive},
+		{"CountLive", testCountLive},
+		{"AddDeadNew", testAddDeadNew},
+		{"AddDeadExisting", testAddDeadExisting},
+		{"GetDeadNoKey", testGetDeadNoKey},
+		{"RemoveDead", testRemoveDead},
+		{"ListDead", testListDead},
+		{"CountDead", testCountDead},
+		{"Events", testEvents},
+		{"ListAllEvents", testListAllEvents},
+		{"AddEmail", testAddEmail},
+		{"Cutoff", testCutoff},
+		{"ListEmail", testListEmail},
+		{"Nodes", testNodes},
+		{"Tokens", testTokens},
+		{"Audit", testAudit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, storage)
+		})
+	}
+}
+
+func testAddLiveNew(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveLive("FOO", time.Now())
+	defer storage.RemoveLive("FOO", time.Now().Add(1*time.Hour))
+	ts1 := time.Now()
+	time.Sleep(time.Millisecond)
+	err := storage.AddLive("FOO")
+	time.Sleep(time.Millisecond)
+	ts2 := time.Now()
+	assert.NilError(t, err)
+	ts, ok, err := storage.GetLive("FOO")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts1.Before(ts) && ts.Before(ts2), true)
+}
+
+func testAddLiveExisting(t *testing.T, storage sentry_store.Store) {
+	defer storage.RemoveLive("FOO", time.Now().Add(1*time.Hour))
+	err := storage.AddLive("FOO")
+	time.Sleep(time.Millisecond)
+	ts1 := time.Now()
+	time.Sleep(time.Millisecond)
+	err = storage.AddLive("FOO")
+	time.Sleep(time.Millisecond)
+	ts2 := time.Now()
+	assert.NilError(t, err)
+	ts, ok, err := storage.GetLive("FOO")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts1.Before(ts) && ts.Before(ts2), true)
+}
+
+func testAddLiveAt(t *testing.T, storage sentry_store.Store) {
+	defer storage.RemoveLive("FOO", time.Now().Add(1*time.Hour))
+	lastSeen := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	err := storage.AddLiveAt("FOO", lastSeen)
+	assert.NilError(t, err)
+	ts, ok, err := storage.GetLive("FOO")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts.Equal(lastSeen), true)
+}
+
+func testGetLiveNoKey(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveLive("NOEXIST", time.Now())
+	_, ok, err := storage.GetLive("NOEXIST")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testRemoveLive(t *testing.T, storage sentry_store.Store) {
+	err := storage.RemoveLive("FOO", time.Now())
+	assert.NilError(t, err)
+
+	err = storage.AddLive("FOO")
+	assert.NilError(t, err)
+	_, ok, err := storage.GetLive("FOO")
+	assert.Equal(t, ok, true)
+	assert.NilError(t, err)
+
+	err = storage.RemoveLive("FOO", time.Now())
+	assert.NilError(t, err)
+
+	_, ok, err = storage.GetLive("FOO")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testListLive(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveLive("FOO1", time.Now())
+	storage.RemoveLive("FOO2", time.Now())
+	storage.RemoveLive("FOO3", time.Now())
+	storage.RemoveLive("FOO4", time.Now())
+	storage.RemoveLive("FOO5", time.Now())
+	defer storage.RemoveLive("FOO1", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO2", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO3", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO4", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO5", time.Now().Add(1*time.Hour))
+
+	startTs := time.Now()
+
+	list, err := storage.ListLive(time.Now())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, list, make([]sentry_store.CallsignTime, 0))
+
+	storage.AddLive("FOO1")
+	storage.AddLive("FOO2")
+	storage.AddLive("FOO3")
+	ts := time.Now()
+
+	list, err = storage.ListLive(ts)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 3)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].Callsign, "FOO2")
+	assert.Equal(t, list[2].Callsign, "FOO3")
+
+	storage.AddLive("FOO4")
+	storage.AddLive("FOO5")
+
+	list, err = storage.ListLive(ts)
+	assert.Equal(t, len(list), 3)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].Callsign, "FOO2")
+	assert.Equal(t, list[2].Callsign, "FOO3")
+
+	list, err = storage.ListLive(time.Now())
+	assert.Equal(t, len(list), 5)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].Callsign, "FOO2")
+	assert.Equal(t, list[2].Callsign, "FOO3")
+	assert.Equal(t, list[3].Callsign, "FOO4")
+	assert.Equal(t, list[4].Callsign, "FOO5")
+
+	lastSeen := startTs
+	for _, v := range list {
+		assert.Equal(t, lastSeen.Before(v.LastSeen), true)
+		lastSeen = v.LastSeen
+	}
+	lastSeen.Before(time.Now())
+}
+
+func testCountLive(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveLive("FOO1", time.Now())
+	storage.RemoveLive("FOO2", time.Now())
+	storage.RemoveLive("FOO3", time.Now())
+	storage.RemoveLive("FOO4", time.Now())
+	storage.RemoveLive("FOO5", time.Now())
+	defer storage.RemoveLive("FOO1", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO2", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO3", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO4", time.Now().Add(1*time.Hour))
+	defer storage.RemoveLive("FOO5", time.Now().Add(1*time.Hour))
+
+	count, err := storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 0)
+
+	err = storage.AddLive("FOO1")
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+
+	err = storage.AddLive("FOO2")
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 2)
+
+	err = storage.AddLive("FOO3")
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 3)
+
+	err = storage.RemoveLive("FOO1", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 2)
+
+	err = storage.RemoveLive("FOO2", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+
+	err = storage.RemoveLive("FOO3", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountLive()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 0)
+}
+
+func testAddDeadNew(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveDead("FOO")
+	defer storage.RemoveDead("FOO")
+	ts1 := time.Now()
+	time.Sleep(time.Millisecond)
+	err := storage.AddDead("FOO", time.Now())
+	time.Sleep(time.Millisecond)
+	ts2 := time.Now()
+	assert.NilError(t, err)
+	ts, ok, err := storage.GetDead("FOO")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts1.Before(ts) && ts.Before(ts2), true)
+}
+
+func testAddDeadExisting(t *testing.T, storage sentry_store.Store) {
+	defer storage.RemoveDead("FOO")
+	err := storage.AddDead("FOO", time.Now())
+	ts1 := time.Now()
+	time.Sleep(time.Millisecond)
+	err = storage.AddDead("FOO", time.Now())
+	time.Sleep(time.Millisecond)
+	ts2 := time.Now()
+	assert.NilError(t, err)
+	ts, ok, err := storage.GetDead("FOO")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, ts1.Before(ts) && ts.Before(ts2), true)
+}
+
+func testGetDeadNoKey(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveDead("NOEXIST")
+	_, ok, err := storage.GetDead("NOEXIST")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testRemoveDead(t *testing.T, storage sentry_store.Store) {
+	err := storage.RemoveDead("FOO")
+	assert.NilError(t, err)
+
+	err = storage.AddDead("FOO", time.Now())
+	assert.NilError(t, err)
+	_, ok, err := storage.GetDead("FOO")
+	assert.Equal(t, ok, true)
+	assert.NilError(t, err)
+
+	err = storage.RemoveDead("FOO")
+	assert.NilError(t, err)
+
+	_, ok, err = storage.GetDead("FOO")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testListDead(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveDead("FOO1")
+	storage.RemoveDead("FOO2")
+	storage.RemoveDead("FOO3")
+	storage.RemoveDead("FOO4")
+	storage.RemoveDead("FOO5")
+	defer storage.RemoveDead("FOO1")
+	defer storage.RemoveDead("FOO2")
+	defer storage.RemoveDead("FOO3")
+	defer storage.RemoveDead("FOO4")
+	defer storage.RemoveDead("FOO5")
+
+	startTs := time.Now()
+
+	list, err := storage.ListDead()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, list, make([]sentry_store.CallsignTime, 0))
+
+	storage.AddDead("FOO1", time.Now())
+	storage.AddDead("FOO2", time.Now())
+	storage.AddDead("FOO3", time.Now())
+
+	list, err = storage.ListDead()
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 3)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].Callsign, "FOO2")
+	assert.Equal(t, list[2].Callsign, "FOO3")
+
+	storage.AddDead("FOO4", time.Now())
+	storage.AddDead("FOO5", time.Now())
+
+	list, err = storage.ListDead()
+	assert.Equal(t, len(list), 5)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].Callsign, "FOO2")
+	assert.Equal(t, list[2].Callsign, "FOO3")
+	assert.Equal(t, list[3].Callsign, "FOO4")
+	assert.Equal(t, list[4].Callsign, "FOO5")
+
+	lastSeen := startTs
+	for _, v := range list {
+		assert.Equal(t, lastSeen.Before(v.LastSeen), true)
+		lastSeen = v.LastSeen
+	}
+	lastSeen.Before(time.Now())
+}
+
+func testCountDead(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveDead("FOO1")
+	storage.RemoveDead("FOO2")
+	storage.RemoveDead("FOO3")
+	storage.RemoveDead("FOO4")
+	storage.RemoveDead("FOO5")
+	defer storage.RemoveDead("FOO1")
+	defer storage.RemoveDead("FOO2")
+	defer storage.RemoveDead("FOO3")
+	defer storage.RemoveDead("FOO4")
+	defer storage.RemoveDead("FOO5")
+
+	count, err := storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 0)
+
+	err = storage.AddDead("FOO1", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+
+	err = storage.AddDead("FOO2", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 2)
+
+	err = storage.AddDead("FOO3", time.Now())
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 3)
+
+	err = storage.RemoveDead("FOO1")
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 2)
+
+	err = storage.RemoveDead("FOO2")
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+
+	err = storage.RemoveDead("FOO3")
+	assert.NilError(t, err)
+	count, err = storage.CountDead()
+	assert.NilError(t, err)
+	assert.Equal(t, count, 0)
+}
+
+func testEvents(t *testing.T, storage sentry_store.Store) {
+	err := storage.RemoveEvents("FOO")
+	assert.NilError(t, err)
+	defer storage.RemoveEvents("FOO")
+	defer storage.RemoveEvents("FOO-1")
+
+	list, err := storage.ListEvents("FOO", time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 0)
+
+	start := time.Now().Add(-3 * time.Hour)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO", Event: sentry_store.EventFirstSeen, Timestamp: start})
+	assert.NilError(t, err)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO", Event: sentry_store.EventWentDown, Timestamp: start.Add(1 * time.Hour)})
+	assert.NilError(t, err)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO", Event: sentry_store.EventCameBack, Timestamp: start.Add(2 * time.Hour), Outage: time.Hour})
+	assert.NilError(t, err)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO-1", Event: sentry_store.EventFirstSeen, Timestamp: start})
+	assert.NilError(t, err)
+
+	list, err = storage.ListEvents("FOO", time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 3)
+	assert.Equal(t, list[0].Event, sentry_store.EventFirstSeen)
+	assert.Equal(t, list[1].Event, sentry_store.EventWentDown)
+	assert.Equal(t, list[2].Event, sentry_store.EventCameBack)
+	assert.Equal(t, list[2].Outage, time.Hour)
+
+	list, err = storage.ListEvents("FOO", start.Add(90*time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 1)
+	assert.Equal(t, list[0].Callsign, "FOO")
+	assert.Equal(t, list[0].Event, sentry_store.EventCameBack)
+
+	// an event with the same timestamp must not replace the earlier one
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO", Event: sentry_store.EventWentDown, Timestamp: start.Add(2 * time.Hour)})
+	assert.NilError(t, err)
+	list, err = storage.ListEvents("FOO", start.Add(90*time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].Event != list[1].Event, true)
+
+	err = storage.RemoveEvents("FOO")
+	assert.NilError(t, err)
+	list, err = storage.ListEvents("FOO", time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 0)
+
+	list, err = storage.ListEvents("FOO-1", time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 1)
+}
+
+func testListAllEvents(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveEvents("FOO1")
+	storage.RemoveEvents("FOO2")
+	defer storage.RemoveEvents("FOO1")
+	defer storage.RemoveEvents("FOO2")
+
+	start := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	err := storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO2", Event: sentry_store.EventFirstSeen, Timestamp: start})
+	assert.NilError(t, err)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO1", Event: sentry_store.EventWentDown, Timestamp: start.Add(time.Hour)})
+	assert.NilError(t, err)
+	err = storage.AddEvent(sentry_store.CallsignEvent{Callsign: "FOO1", Event: sentry_store.EventFirstSeen, Timestamp: start})
+	assert.NilError(t, err)
+
+	all, err := storage.ListAllEvents()
+	assert.NilError(t, err)
+	// other tests may share the backend, so only look at our callsigns
+	list := make([]sentry_store.CallsignEvent, 0)
+	for _, event := range all {
+		if event.Callsign == "FOO1" || event.Callsign == "FOO2" {
+			list = append(list, event)
+		}
+	}
+	assert.Equal(t, len(list), 3)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[0].Event, sentry_store.EventFirstSeen)
+	assert.Equal(t, list[1].Callsign, "FOO1")
+	assert.Equal(t, list[1].Event, sentry_store.EventWentDown)
+	assert.Equal(t, list[2].Callsign, "FOO2")
+	assert.Equal(t, list[2].Timestamp.Equal(start), true)
+}
+
+func testAddEmail(t *testing.T, storage sentry_store.Store) {
+	err := storage.RemoveEmail("foo")
+	assert.NilError(t, err)
+
+	// duplicate to test when definitely empty
+	err = storage.RemoveEmail("foo")
+	assert.NilError(t, err)
+
+	email, ok, err := storage.GetEmail("foo")
+	assert.Equal(t, email, "")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+
+	err = storage.AddEmail("foo", "bar")
+	assert.NilError(t, err)
+
+	email, ok, err = storage.GetEmail("foo")
+	assert.Equal(t, email, "bar")
+	assert.Equal(t, ok, true)
+	assert.NilError(t, err)
+
+	err = storage.AddEmail("foo", "bar,jitsu")
+	assert.NilError(t, err)
+
+	email, ok, err = storage.GetEmail("foo")
+	assert.Equal(t, email, "bar,jitsu")
+	assert.Equal(t, ok, true)
+	assert.NilError(t, err)
+
+	err = storage.RemoveEmail("foo")
+	assert.NilError(t, err)
+
+	email, ok, err = storage.GetEmail("foo")
+	assert.Equal(t, email, "")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testCutoff(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveCutoff("foo1")
+	storage.RemoveCutoff("foo2")
+	defer storage.RemoveCutoff("foo1")
+	defer storage.RemoveCutoff("foo2")
+
+	cutoff, ok, err := storage.GetCutoff("foo1")
+	assert.Equal(t, cutoff, time.Duration(0))
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+
+	err = storage.AddCutoff("foo1", 10*time.Minute)
+	assert.NilError(t, err)
+	err = storage.AddCutoff("foo2", 6*time.Hour)
+	assert.NilError(t, err)
+	err = storage.AddCutoff("foo1", 30*time.Minute)
+	assert.NilError(t, err)
+
+	cutoff, ok, err = storage.GetCutoff("foo1")
+	assert.Equal(t, cutoff, 30*time.Minute)
+	assert.Equal(t, ok, true)
+	assert.NilError(t, err)
+
+	list, err := storage.ListCutoff()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, list, []sentry_store.CallsignCutoff{{"foo1", 30 * time.Minute}, {"foo2", 6 * time.Hour}})
+
+	err = storage.RemoveCutoff("foo1")
+	assert.NilError(t, err)
+	_, ok, err = storage.GetCutoff("foo1")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testListEmail(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveEmail("foo1")
+	storage.RemoveEmail("foo2")
+	storage.RemoveEmail("foo3")
+	storage.RemoveEmail("foo4")
+	storage.RemoveEmail("foo5")
+	defer storage.RemoveEmail("foo1")
+	defer storage.RemoveEmail("foo2")
+	defer storage.RemoveEmail("foo3")
+	defer storage.RemoveEmail("foo4")
+	defer storage.RemoveEmail("foo5")
+
+	list, err := storage.ListEmail()
+	assert.Equal(t, len(list), 0)
+	assert.NilError(t, err)
+
+	storage.AddEmail("foo1", "bar1")
+	storage.AddEmail("foo2", "bar2")
+	storage.AddEmail("foo3", "bar3")
+	storage.AddEmail("foo4", "bar4")
+	storage.AddEmail("foo5", "bar5")
+
+	expectedList := make([]sentry_store.CallsignEmail, 5, 5)
+	expectedList[0] = sentry_store.CallsignEmail{"foo1", "bar1"}
+	expectedList[1] = sentry_store.CallsignEmail{"foo2", "bar2"}
+	expectedList[2] = sentry_store.CallsignEmail{"foo3", "bar3"}
+	expectedList[3] = sentry_store.CallsignEmail{"foo4", "bar4"}
+	expectedList[4] = sentry_store.CallsignEmail{"foo5", "bar5"}
+
+	list, err = storage.ListEmail()
+	assert.DeepEqual(t, list, expectedList)
+	assert.NilError(t, err)
+
+	storage.RemoveEmail("foo4")
+	storage.RemoveEmail("foo5")
+
+	expectedList = expectedList[0:3]
+
+	list, err = storage.ListEmail()
+	assert.DeepEqual(t, list, expectedList)
+	assert.NilError(t, err)
+
+	storage.RemoveEmail("foo1")
+	storage.RemoveEmail("foo2")
+	storage.RemoveEmail("foo3")
+
+	list, err = storage.ListEmail()
+	assert.Equal(t, len(list), 0)
+	assert.NilError(t, err)
+}
+
+func testNodes(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveNode("FOO1")
+	storage.RemoveNode("FOO2")
+	defer storage.RemoveNode("FOO1")
+	defer storage.RemoveNode("FOO2")
+
+	_, ok, err := storage.GetNode("FOO1")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+
+	altitude := 1234.5
+	updated := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	node := sentry_store.NodeInfo{
+		Callsign:    "FOO1",
+		Updated:     updated,
+		Latitude:    37.368333,
+		Longitude:   -121.985,
+		Altitude:    &altitude,
+		SymbolTable: "/",
+		SymbolCode:  "#",
+		Comment:     "Mountain top digi",
+		Path:        []string{"WIDE1-1", "WIDE2-1"},
+		Igate:       "BAR-10",
+	}
+	err = storage.AddNode(node)
+	assert.NilError(t, err)
+	err = storage.AddNode(sentry_store.NodeInfo{Callsign: "FOO2", Updated: updated, SymbolTable: "\\", SymbolCode: "&"})
+	assert.NilError(t, err)
+
+	got, ok, err := storage.GetNode("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, got.Updated.Equal(updated), true)
+	got.Updated = updated
+	assert.DeepEqual(t, got, node)
+
+	node.Comment = "Moved"
+	node.Altitude = nil
+	node.Path = nil
+	err = storage.AddNode(node)
+	assert.NilError(t, err)
+	got, ok, err = storage.GetNode("FOO1")
+	assert.NilError(t, err)
+	assert.Equal(t, got.Comment, "Moved")
+	assert.Equal(t, got.Altitude == nil, true)
+	assert.Equal(t, len(got.Path), 0)
+
+	all, err := storage.ListNodes()
+	assert.NilError(t, err)
+	list := make([]sentry_store.NodeInfo, 0)
+	for _, v := range all {
+		if v.Callsign == "FOO1" || v.Callsign == "FOO2" {
+			list = append(list, v)
+		}
+	}
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].Callsign, "FOO1")
+	assert.Equal(t, list[1].SymbolTable, "\\")
+
+	err = storage.RemoveNode("FOO1")
+	assert.NilError(t, err)
+	_, ok, err = storage.GetNode("FOO1")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testTokens(t *testing.T, storage sentry_store.Store) {
+	storage.RemoveToken("portal")
+	storage.RemoveToken("monitor")
+	defer storage.RemoveToken("portal")
+	defer storage.RemoveToken("monitor")
+
+	_, ok, err := storage.GetToken("portal")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+
+	created := time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC)
+	token := sentry_store.ApiToken{Name: "portal", Hash: "aa11", Scope: sentry_store.ScopeManage, Created: created}
+	assert.NilError(t, storage.AddToken(token))
+	assert.NilError(t, storage.AddToken(sentry_store.ApiToken{Name: "monitor", Hash: "bb22", Scope: sentry_store.ScopeRead, Created: created}))
+
+	got, ok, err := storage.GetToken("portal")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, got.Created.Equal(created), true)
+	got.Created = created
+	assert.DeepEqual(t, got, token)
+
+	token.Hash = "cc33"
+	assert.NilError(t, storage.AddToken(token))
+	got, _, err = storage.GetToken("portal")
+	assert.NilError(t, err)
+	assert.Equal(t, got.Hash, "cc33")
+
+	all, err := storage.ListTokens()
+	assert.NilError(t, err)
+	list := make([]sentry_store.ApiToken, 0)
+	for _, v := range all {
+		if v.Name == "portal" || v.Name == "monitor" {
+			list = append(list, v)
+		}
+	}
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].Name, "monitor")
+	assert.Equal(t, list[0].Scope, sentry_store.ScopeRead)
+	assert.Equal(t, list[1].Name, "portal")
+
+	assert.NilError(t, storage.RemoveToken("portal"))
+	_, ok, err = storage.GetToken("portal")
+	assert.Equal(t, ok, false)
+	assert.NilError(t, err)
+}
+
+func testAudit(t *testing.T, storage sentry_store.Store) {
+	// the audit log is append only, so only look at what this run wrote
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	entries := []sentry_store.AuditEntry{
+		{Timestamp: start.Add(time.Millisecond), Actor: "storetest", Action: "email.add", Target: "FOO", Detail: "foo@example.com"},
+		{Timestamp: start.Add(2 * time.Millisecond), Actor: "storetest", Action: "email.remove", Target: "FOO"},
+		// same timestamp must not overwrite the previous entry
+		{Timestamp: start.Add(2 * time.Millisecond), Actor: "storetest", Action: "cutoff.remove", Target: "FOO"},
+	}
+	for _, entry := range entries {
+		assert.NilError(t, storage.AddAudit(entry))
+	}
+
+	all, err := storage.ListAudit(start)
+	assert.NilError(t, err)
+	list := make([]sentry_store.AuditEntry, 0)
+	for _, v := range all {
+		if v.Actor == "storetest" {
+			v.Timestamp = v.Timestamp.UTC()
+			list = append(list, v)
+		}
+	}
+	assert.Equal(t, len(list), 3)
+	assert.DeepEqual(t, list[0], entries[0])
+	assert.Equal(t, list[1].Timestamp.Equal(entries[1].Timestamp), true)
+	assert.Equal(t, list[2].Timestamp.Equal(entries[2].Timestamp), true)
+
+	// the zero time lists the whole log
+	all, err = storage.ListAudit(time.Time{})
+	assert.NilError(t, err)
+	count := 0
+	for _, v := range all {
+		if v.Actor == "storetest" {
+			count++
+		}
+	}
+	assert.Equal(t, count >= 3, true)
+
+	later, err := storage.ListAudit(start.Add(2 * time.Millisecond))
+	assert.NilError(t, err)
+	count = 0
+	for _, v := range later {
+		if v.Actor == "storetest" {
+			count++
+		}
+	}
+	assert.Equal(t, count, 2)
+}
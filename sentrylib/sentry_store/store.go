@@ -5,6 +5,10 @@ import "time"
 type Store interface {
 	EmailAddressStore
 	EntryStore
+	HistoryStore
+	NodeStore
+	AdminStore
+	Close() error
 }
 
 type CallsignTime struct {
@@ -17,8 +21,32 @@ type CallsignEmail struct {
 	Email    string
 }
 
+// CallsignCutoff overrides the global Cutoff for a single callsign.
+type CallsignCutoff struct {
+	Callsign string
+	Cutoff   time.Duration
+}
+
+type EventType string
+
+const (
+	EventFirstSeen EventType = "first-seen"
+	EventWentDown  EventType = "went-down"
+	EventCameBack  EventType = "came-back"
+)
+
+// CallsignEvent records a single up/down transition for a callsign. Outage is
+// only set for EventCameBack and holds the time since the node was last heard.
+type CallsignEvent struct {
+	Callsign  string
+	Event     EventType
+	Timestamp time.Time
+	Outage    time.Duration `json:",omitempty"`
+}
+
 type EntryStore interface {
 	AddLive(callsign string) error
+	AddLiveAt(callsign string, ts time.Time) error
 	CountLive() (int, error)
 	GetLive(callsign string) (time.Time, bool, error)
 	ListLive(ts time.Time) ([]CallsignTime, error)
@@ -38,4 +66,89 @@ type EmailAddressStore interface {
 	GetEmail(callsign string) (string, bool, error)
 	ListEmail() ([]CallsignEmail, error)
 	RemoveEmail(callsign string) error
+
+	AddCutoff(callsign string, cutoff time.Duration) error
+	GetCutoff(callsign string) (time.Duration, bool, error)
+	ListCutoff() ([]CallsignCutoff, error)
+	RemoveCutoff(callsign string) error
+}
+
+// HistoryStore keeps every transition so past outages survive after the live
+// and dead entries have been overwritten. ListEvents returns events for a
+// callsign at or after since, oldest first. ListAllEvents returns every event
+// ordered by callsign, then time.
+type HistoryStore interface {
+	AddEvent(event CallsignEvent) error
+	ListEvents(callsign string, since time.Time) ([]CallsignEvent, error)
+	ListAllEvents() ([]CallsignEvent, error)
+	RemoveEvents(callsign string) error
+}
+
+// NodeInfo is what was decoded from the last position report heard from a
+// callsign. Altitude is in meters and nil unless the report carried one. Path
+// holds the digipeaters the packet went through and Igate the station that
+// gated it to APRS-IS.
+type NodeInfo struct {
+	Callsign    string
+	Updated     time.Time
+	Latitude    float64
+	Longitude   float64
+	Altitude    *float64 `json:",omitempty"`
+	SymbolTable string
+	SymbolCode  string
+	Comment     string   `json:",omitempty"`
+	Path        []string `json:",omitempty"`
+	Igate       string   `json:",omitempty"`
+}
+
+// NodeStore keeps the latest NodeInfo per callsign. AddNode replaces any
+// earlier entry and ListNodes is ordered by callsign.
+type NodeStore interface {
+	AddNode(node NodeInfo) error
+	GetNode(callsign string) (NodeInfo, bool, error)
+	ListNodes() ([]NodeInfo, error)
+	RemoveNode(callsign string) error
+}
+
+type TokenScope string
+
+const (
+	// ScopeRead allows reading the admin API.
+	ScopeRead TokenScope = "read"
+	// ScopeManage also allows changes.
+	ScopeManage TokenScope = "manage"
+)
+
+// ApiToken is a named bearer token for the admin API. Only the hex encoded
+// SHA-256 of the secret is stored; the secret itself is shown once when the
+// token is created.
+type ApiToken struct {
+	Name    string
+	Hash    string
+	Scope   TokenScope
+	Created time.Time
+}
+
+// AuditEntry records a change made through the admin API or the command
+// line. Actor is the token name, or a fixed name such as "local" for
+// changes that did not use a token.
+type AuditEntry struct {
+	Timestamp time.Time
+	Actor     string
+	Action    string
+	Target    string
+	Detail    string `json:",omitempty"`
+}
+
+// AdminStore keeps API tokens and the audit log. Tokens are keyed by name and
+// AddToken replaces an existing token of the same name. ListTokens is ordered
+// by name. ListAudit returns entries at or after since, oldest first.
+type AdminStore interface {
+	AddToken(token ApiToken) error
+	GetToken(name string) (ApiToken, bool, error)
+	ListTokens() ([]ApiToken, error)
+	RemoveToken(name string) error
+
+	AddAudit(entry AuditEntry) error
+	ListAudit(since time.Time) ([]AuditEntry, error)
 }
@@ -1,39 +1,84 @@
 package sentrylib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dustin/go-aprs"
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
 	"log"
+	"sync"
 	"time"
 )
 
 type SentryWorker interface {
-	HandleMessage(frame aprs.Frame) error
+	HandleMessage(ctx context.Context, frame aprs.Frame) error
 	ReapLiveNodes() ([]sentry_store.CallsignTime, error)
-	Email(callsign string, ts time.Time)
+	NotifyDown(ctx context.Context, callsign string, ts time.Time)
+	NotifyRecovery(ctx context.Context, callsign string, ts time.Time, outage time.Duration)
 	LastSeen() (time.Time, error)
+	Wait()
 }
 
 type sentryWorker struct {
-	store    sentry_store.Store
-	duration time.Duration
-	mail     Mail
+	store     sentry_store.Store
+	duration  time.Duration
+	adaptive  *AdaptiveCutoff
+	notifiers []Notifier
+	// graceStart is when the worker started, unless the cooldown is
+	// skipped. Nodes are not reaped for silence before it.
+	graceStart time.Time
+
+	inflight sync.WaitGroup
 }
 
 var FrameNotValidError error = errors.New("Frame Not Valid")
 var EmptyCallsignError error = errors.New("No Callsign")
 
-func NewSentryWorker(store sentry_store.Store, liveDuration time.Duration, mail Mail) SentryWorker {
-	return &sentryWorker{
-		store:    store,
-		duration: liveDuration,
-		mail:     mail,
+// NewSentryWorker creates a worker that reaps nodes not heard from within
+// liveDuration. If adaptive is non-nil, nodes with a learned beacon interval
+// use that instead; per-callsign cutoffs in the store take precedence over both.
+//
+// Unless skipCooldown is set, silence is only counted from when the worker was
+// created, so nodes heard before a restart get a chance to beacon again. The
+// cooldown is per node: once a node's interval has been learned again its
+// adaptive cutoff applies, without waiting out the global one.
+func NewSentryWorker(store sentry_store.Store, liveDuration time.Duration, adaptive *AdaptiveCutoff, notifiers []Notifier, skipCooldown bool) SentryWorker {
+	worker := &sentryWorker{
+		store:     store,
+		duration:  liveDuration,
+		adaptive:  adaptive,
+		notifiers: notifiers,
+	}
+	if !skipCooldown {
+		worker.graceStart = time.Now()
+	}
+	return worker
+}
+
+// updateStatus stores the text of a status report as the comment of a
+// known node. Status reports carry no position, so unknown nodes are skipped.
+func (worker *sentryWorker) updateStatus(callsign, status string) {
+	if status == "" {
+		return
+	}
+	node, ok, err := worker.store.GetNode(callsign)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !ok {
+		return
+	}
+	node.Comment = status
+	if err := worker.store.AddNode(node); err != nil {
+		log.Println("Unable to store node metadata:", err)
 	}
 }
 
-func (worker *sentryWorker) HandleMessage(frame aprs.Frame) error {
+// HandleMessage records a packet. Recovery notifications are sent in the
+// background with ctx; use Wait to let them finish.
+func (worker *sentryWorker) HandleMessage(ctx context.Context, frame aprs.Frame) error {
 	if !frame.IsValid() {
 		return FrameNotValidError
 	}
@@ -47,14 +92,46 @@ func (worker *sentryWorker) HandleMessage(frame aprs.Frame) error {
 	if err != nil {
 		return err
 	}
-	pos, err := frame.Body.Position()
+	pos, err := framePosition(frame)
 	if err != nil {
+		if frame.Body.Type() == '>' {
+			worker.updateStatus(callsign, statusText(frame.Body))
+		}
 		return err
 	}
 
+	now := time.Now()
+	node := NewNodeInfo(frame, pos, now)
+	if node.Comment == "" {
+		// keep the last comment or status when a report carries none
+		if previous, found, err := worker.store.GetNode(callsign); err == nil && found {
+			node.Comment = previous.Comment
+		}
+	}
+	err = worker.store.AddNode(node)
+	if err != nil {
+		log.Println("Unable to store node metadata:", err)
+	}
+
+	if ok && worker.adaptive != nil {
+		worker.adaptive.Observe(callsign, now.Sub(ts))
+	}
+	if !ok {
+		event, err := worker.recordReturn(callsign, now)
+		if err != nil {
+			return err
+		}
+		if event.Event == sentry_store.EventCameBack {
+			worker.inflight.Add(1)
+			go func() {
+				defer worker.inflight.Done()
+				worker.NotifyRecovery(ctx, callsign, now.Add(-event.Outage), event.Outage)
+			}()
+		}
+	}
+
 	worker.store.RemoveDead(callsign)
 	worker.store.AddLive(callsign)
-	now := time.Now()
 
 	symbol := pos.Symbol.Glyph()
 	count, err := worker.store.CountLive()
@@ -77,31 +154,117 @@ func (worker *sentryWorker) HandleMessage(frame aprs.Frame) error {
 }
 
 func (worker *sentryWorker) ReapLiveNodes() ([]sentry_store.CallsignTime, error) {
-	duration := -1 * worker.duration
-	cutoff := time.Now().Add(duration)
-	nodes, err := worker.store.ListLive(cutoff)
+	overrides, err := worker.store.ListCutoff()
+	if err != nil {
+		return nil, err
+	}
+	cutoffs := make(map[string]time.Duration, len(overrides))
+	shortest := worker.duration
+	for _, v := range overrides {
+		cutoffs[v.Callsign] = v.Cutoff
+		if v.Cutoff < shortest {
+			shortest = v.Cutoff
+		}
+	}
+	if worker.adaptive != nil && worker.adaptive.Floor() < shortest {
+		shortest = worker.adaptive.Floor()
+	}
+
+	now := time.Now()
+	candidates, err := worker.store.ListLive(now.Add(-1 * shortest))
 	if err != nil {
 		return nil, err
 	}
 
-	for k, v := range nodes {
-		log.Println("Reaping:", k, v)
+	nodes := make([]sentry_store.CallsignTime, 0, len(candidates))
+	for _, v := range candidates {
+		duration, ok := cutoffs[v.Callsign]
+		if !ok && worker.adaptive != nil {
+			duration, ok = worker.adaptive.Cutoff(v.Callsign)
+		}
+		if !ok {
+			duration = worker.duration
+		}
+		cutoff := now.Add(-1 * duration)
+		if v.LastSeen.After(cutoff) || worker.graceStart.After(cutoff) {
+			continue
+		}
+		nodes = append(nodes, v)
+
+		log.Println("Reaping:", v)
 		worker.store.RemoveLive(v.Callsign, cutoff)
 		worker.store.AddDead(v.Callsign, v.LastSeen)
+		err = worker.store.AddEvent(sentry_store.CallsignEvent{
+			Callsign:  v.Callsign,
+			Event:     sentry_store.EventWentDown,
+			Timestamp: v.LastSeen,
+		})
+		if err != nil {
+			log.Println(err)
+		}
 	}
 
 	return nodes, nil
 }
 
-func (worker *sentryWorker) Email(callsign string, ts time.Time) {
-	email, ok, err := worker.store.GetEmail(callsign)
+// recordReturn adds a history event for a callsign that is not currently
+// live: came-back if it had been reaped, first-seen otherwise.
+func (worker *sentryWorker) recordReturn(callsign string, now time.Time) (sentry_store.CallsignEvent, error) {
+	deadTs, dead, err := worker.store.GetDead(callsign)
+	if err != nil {
+		return sentry_store.CallsignEvent{}, err
+	}
+	event := sentry_store.CallsignEvent{
+		Callsign:  callsign,
+		Event:     sentry_store.EventFirstSeen,
+		Timestamp: now,
+	}
+	if dead {
+		event.Event = sentry_store.EventCameBack
+		event.Outage = now.Sub(deadTs)
+	}
+	return event, worker.store.AddEvent(event)
+}
+
+// NotifyDown tells every notifier that a node was reaped. ts is when the node
+// was last heard.
+func (worker *sentryWorker) NotifyDown(ctx context.Context, callsign string, ts time.Time) {
+	worker.notify(ctx, Notification{
+		Callsign: callsign,
+		State:    StateDown,
+		LastSeen: ts,
+	})
+}
+
+// NotifyRecovery tells every notifier that a reaped node is beaconing again.
+// ts is when the node was last heard before the outage.
+func (worker *sentryWorker) NotifyRecovery(ctx context.Context, callsign string, ts time.Time, outage time.Duration) {
+	worker.notify(ctx, Notification{
+		Callsign: callsign,
+		State:    StateUp,
+		LastSeen: ts,
+		Outage:   outage,
+	})
+}
+
+func (worker *sentryWorker) notify(ctx context.Context, notification Notification) {
+	node, ok, err := worker.store.GetNode(notification.Callsign)
 	if err != nil {
 		log.Println(err)
+	} else if ok {
+		notification.Position = &Position{node.Latitude, node.Longitude}
 	}
-	if ok {
-		err = worker.mail.Send(email, callsign, ts)
+
+	for _, notifier := range worker.notifiers {
+		err := notifier.Notify(ctx, notification)
+		if err == ErrNoRecipient {
+			continue
+		}
 		if err != nil {
+			notificationsFailed.Inc(notifierName(notifier))
 			log.Println(err)
+		} else {
+			notificationsSent.Inc(notifierName(notifier))
 		}
 	}
 }
@@ -109,3 +272,9 @@ func (worker *sentryWorker) Email(callsign string, ts time.Time) {
 func (worker *sentryWorker) LastSeen() (time.Time, error) {
 	return worker.store.LastSeenLive()
 }
+
+// Wait blocks until background notifications started by HandleMessage have
+// finished.
+func (worker *sentryWorker) Wait() {
+	worker.inflight.Wait()
+}
@@ -0,0 +1,225 @@
+package sentrylib
+
+import (
+	"context"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/dustin/go-aprs"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records every notification it is given.
+type fakeNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (notifier *fakeNotifier) Notify(ctx context.Context, notification Notification) error {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	notifier.notifications = append(notifier.notifications, notification)
+	return nil
+}
+
+func (notifier *fakeNotifier) received() []Notification {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	return append([]Notification(nil), notifier.notifications...)
+}
+
+func positionFrame(callsign string) aprs.Frame {
+	return aprs.ParseFrame(callsign + ">APRS,TCPIP*,qAC,T2TEST:!3722.10N/12159.10W#Test node")
+}
+
+func TestSentryWorker_History(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	worker := NewSentryWorker(store, 10*time.Millisecond, nil, []Notifier{&fakeNotifier{}}, true)
+	ctx := context.Background()
+
+	assert.NilError(t, worker.HandleMessage(ctx, positionFrame("N0CALL-1")))
+	// a second packet while live is not a transition
+	assert.NilError(t, worker.HandleMessage(ctx, positionFrame("N0CALL-1")))
+
+	lastSeen, _, err := store.GetLive("N0CALL-1")
+	assert.NilError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	reaped, err := worker.ReapLiveNodes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(reaped), 1)
+
+	assert.NilError(t, worker.HandleMessage(ctx, positionFrame("N0CALL-1")))
+	worker.Wait()
+
+	events, err := store.ListEvents("N0CALL-1", time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[0].Event, sentry_store.EventFirstSeen)
+	assert.Equal(t, events[1].Event, sentry_store.EventWentDown)
+	assert.Equal(t, events[1].Timestamp.Equal(lastSeen), true)
+	assert.Equal(t, events[2].Event, sentry_store.EventCameBack)
+	assert.Equal(t, events[2].Outage >= 20*time.Millisecond, true)
+}
+
+func TestSentryWorker_RecoveryNotification(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	notifier := &fakeNotifier{}
+	worker := NewSentryWorker(store, time.Hour, nil, []Notifier{notifier}, true)
+
+	lastSeen := time.Now().Add(-3 * time.Hour)
+	assert.NilError(t, store.AddDead("N0CALL-1", lastSeen))
+	assert.NilError(t, worker.HandleMessage(context.Background(), positionFrame("N0CALL-1")))
+	// a node heard for the first time is not a recovery
+	assert.NilError(t, worker.HandleMessage(context.Background(), positionFrame("N0CALL-2")))
+	worker.Wait()
+
+	notifications := notifier.received()
+	assert.Equal(t, len(notifications), 1)
+	assert.Equal(t, notifications[0].Callsign, "N0CALL-1")
+	assert.Equal(t, notifications[0].State, StateUp)
+	assert.Equal(t, notifications[0].LastSeen.Sub(lastSeen) < time.Second, true)
+	assert.Equal(t, notifications[0].Outage >= 3*time.Hour, true)
+	assert.NotNil(t, notifications[0].Position)
+
+	_, dead, err := store.GetDead("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, dead, false)
+	_, live, err := store.GetLive("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, live, true)
+}
+
+func TestSentryWorker_CutoffOverride(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	worker := NewSentryWorker(store, 25*time.Hour, nil, []Notifier{&fakeNotifier{}}, true)
+
+	lastSeen := time.Now().Add(-2 * time.Hour)
+	assert.NilError(t, store.AddLiveAt("N0CALL-1", lastSeen))
+	assert.NilError(t, store.AddLiveAt("N0CALL-2", lastSeen))
+	assert.NilError(t, store.AddCutoff("N0CALL-1", time.Hour))
+	// an override longer than the global cutoff holds the node longer
+	assert.NilError(t, store.AddLiveAt("N0CALL-3", time.Now().Add(-26*time.Hour)))
+	assert.NilError(t, store.AddCutoff("N0CALL-3", 48*time.Hour))
+
+	reaped, err := worker.ReapLiveNodes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(reaped), 1)
+	assert.Equal(t, reaped[0].Callsign, "N0CALL-1")
+
+	_, dead, err := store.GetDead("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, dead, true)
+	for _, callsign := range []string{"N0CALL-2", "N0CALL-3"} {
+		_, live, err := store.GetLive(callsign)
+		assert.NilError(t, err)
+		assert.Equal(t, live, true)
+	}
+}
+
+func TestRunReaper_AppliesAdaptiveCutoff(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	notifier := &fakeNotifier{}
+	adaptive := NewAdaptiveCutoff(3, 3, 30*time.Minute, 25*time.Hour)
+	worker := NewSentryWorker(store, 25*time.Hour, adaptive, []Notifier{notifier}, true)
+
+	// N0CALL-1 beacons every 10 minutes, so 45 minutes of silence is an
+	// outage; N0CALL-2 has not been learned and keeps the global cutoff
+	for i := 0; i < 3; i++ {
+		adaptive.Observe("N0CALL-1", 10*time.Minute)
+	}
+	lastSeen := time.Now().Add(-45 * time.Minute)
+	assert.NilError(t, store.AddLiveAt("N0CALL-1", lastSeen))
+	assert.NilError(t, store.AddLiveAt("N0CALL-2", lastSeen))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunReaper(ctx, worker)
+		close(done)
+	}()
+	for i := 0; i < 100 && len(notifier.received()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	notifications := notifier.received()
+	assert.Equal(t, len(notifications), 1)
+	assert.Equal(t, notifications[0].Callsign, "N0CALL-1")
+	assert.Equal(t, notifications[0].State, StateDown)
+	_, live, err := store.GetLive("N0CALL-2")
+	assert.NilError(t, err)
+	assert.Equal(t, live, true)
+}
+
+func TestSentryWorker_Cooldown(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	adaptive := NewAdaptiveCutoff(3, 3, 30*time.Minute, 25*time.Hour)
+	worker := NewSentryWorker(store, 25*time.Hour, adaptive, []Notifier{&fakeNotifier{}}, false)
+
+	// silence from before the restart does not count
+	assert.NilError(t, store.AddLiveAt("N0CALL-1", time.Now().Add(-30*time.Hour)))
+	assert.NilError(t, store.AddLiveAt("N0CALL-2", time.Now().Add(-45*time.Minute)))
+	for i := 0; i < 3; i++ {
+		adaptive.Observe("N0CALL-2", 10*time.Minute)
+	}
+	reaped, err := worker.ReapLiveNodes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(reaped), 0)
+
+	// an hour after the restart the learned cutoff applies, while the node
+	// without one still waits for the global cutoff
+	worker.(*sentryWorker).graceStart = time.Now().Add(-time.Hour)
+	reaped, err = worker.ReapLiveNodes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(reaped), 1)
+	assert.Equal(t, reaped[0].Callsign, "N0CALL-2")
+}
+
+// blockingNotifier holds every notification until release is closed.
+type blockingNotifier struct {
+	fakeNotifier
+	release chan struct{}
+}
+
+func (notifier *blockingNotifier) Notify(ctx context.Context, notification Notification) error {
+	notifier.fakeNotifier.Notify(ctx, notification)
+	<-notifier.release
+	return nil
+}
+
+func TestRunReaper_NotifiesInBackground(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	worker := NewSentryWorker(store, time.Hour, nil, []Notifier{notifier}, true)
+	assert.NilError(t, store.AddLiveAt("N0CALL-1", time.Now().Add(-2*time.Hour)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunReaper(ctx, worker)
+		close(done)
+	}()
+	for i := 0; i < 100 && len(notifier.received()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the first notification is stuck, but the reaper keeps reaping
+	assert.NilError(t, store.AddLiveAt("N0CALL-2", time.Now().Add(-2*time.Hour)))
+	for i := 0; i < 300 && len(notifier.received()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, len(notifier.received()), 2)
+
+	// shutting down waits for notifications in flight
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("RunReaper returned with notifications in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(notifier.release)
+	<-done
+}
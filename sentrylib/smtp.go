@@ -0,0 +1,196 @@
+package sentrylib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SmtpSecurityStartTLS = "starttls"
+	SmtpSecurityTLS      = "tls"
+	SmtpSecurityNone     = "none"
+)
+
+type smtpMailer struct {
+	host        string
+	port        int
+	username    string
+	password    string
+	fromAddress string
+	security    string
+	timeout     time.Duration
+}
+
+func NewSmtpServer(config Config) Mail {
+	security := strings.ToLower(config.Smtp.Security)
+	if security == "" {
+		security = SmtpSecurityStartTLS
+	}
+	port := config.Smtp.Port
+	if port == 0 {
+		if security == SmtpSecurityTLS {
+			port = 465
+		} else {
+			port = 587
+		}
+	}
+	return &smtpMailer{
+		host:        config.Smtp.Host,
+		port:        port,
+		username:    config.Smtp.Username,
+		password:    config.Smtp.Password,
+		fromAddress: config.Smtp.FromAddress,
+		security:    security,
+		timeout:     30 * time.Second,
+	}
+}
+
+func (mailer *smtpMailer) Send(email, callsign string, ts time.Time, unsubscribe string) error {
+	subject, body := downMessage(callsign, ts, unsubscribe)
+	return mailer.send(email, subject, body)
+}
+
+func (mailer *smtpMailer) SendRecovery(email, callsign string, ts time.Time, outage time.Duration, unsubscribe string) error {
+	subject, body := recoveryMessage(callsign, ts, outage, unsubscribe)
+	return mailer.send(email, subject, body)
+}
+
+func (mailer *smtpMailer) SendConfirmation(email, callsign, link string) error {
+	subject, body := confirmationMessage(callsign, link)
+	return mailer.send(email, subject, body)
+}
+
+func (mailer *smtpMailer) send(email, subject, body string) error {
+	recipients := splitAddresses(email)
+	if len(recipients) == 0 {
+		return errors.New("No recipients in '" + email + "'")
+	}
+	from, err := envelopeAddress(mailer.fromAddress)
+	if err != nil {
+		return err
+	}
+
+	client, err := mailer.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if mailer.username != "" {
+		err = client.Auth(smtp.PlainAuth("", mailer.username, mailer.password, mailer.host))
+		if err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		to, err := envelopeAddress(recipient)
+		if err != nil {
+			return err
+		}
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(mailer.message(recipients, subject, body))
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (mailer *smtpMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(mailer.host, strconv.Itoa(mailer.port))
+	tlsConfig := &tls.Config{ServerName: mailer.host}
+	dialer := &net.Dialer{Timeout: mailer.timeout}
+
+	var conn net.Conn
+	var err error
+	if mailer.security == SmtpSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(mailer.timeout))
+
+	client, err := smtp.NewClient(conn, mailer.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	switch mailer.security {
+	case SmtpSecurityStartTLS:
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	case SmtpSecurityTLS, SmtpSecurityNone:
+	default:
+		client.Close()
+		return nil, errors.New("Unknown Smtp.Security '" + mailer.security + "'")
+	}
+	return client, nil
+}
+
+func (mailer *smtpMailer) message(recipients []string, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", mailer.fromAddress)
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue(strings.Join(recipients, ", ")))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// headerValue folds line breaks into spaces so a value cannot end its header
+// and start another one.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// splitAddresses splits the comma separated list stored by AddEmail.
+func splitAddresses(email string) []string {
+	recipients := make([]string, 0)
+	for _, v := range strings.Split(email, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			recipients = append(recipients, v)
+		}
+	}
+	return recipients
+}
+
+// envelopeAddress strips a display name such as "Sentry <alert@example.com>"
+// down to the bare address SMTP expects in MAIL FROM and RCPT TO.
+func envelopeAddress(address string) (string, error) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
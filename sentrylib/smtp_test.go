@@ -0,0 +1,160 @@
+package sentrylib
+
+import (
+	"bufio"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer accepts a single plain text session and records what the
+// client sent.
+type fakeSmtpServer struct {
+	listener   net.Listener
+	auth       string
+	from       string
+	recipients []string
+	data       string
+	done       chan struct{}
+}
+
+func newFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	server := &fakeSmtpServer{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go server.serve()
+	return server
+}
+
+func (server *fakeSmtpServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *fakeSmtpServer) serve() {
+	defer close(server.done)
+	conn, err := server.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	write := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	write("220 localhost ESMTP fake")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			write("250-localhost")
+			write("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH PLAIN"):
+			server.auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+			write("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			server.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			write("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			server.recipients = append(server.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			write("250 OK")
+		case command == "DATA":
+			write("354 End data with <CR><LF>.<CR><LF>")
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				server.data += dataLine
+			}
+			write("250 OK")
+		case command == "QUIT":
+			write("221 Bye")
+			return
+		default:
+			write("502 Command not implemented")
+		}
+	}
+}
+
+func TestSmtpServer_Send(t *testing.T) {
+	server := newFakeSmtpServer(t)
+	defer server.listener.Close()
+
+	mail := NewSmtpServer(Config{
+		Smtp: &SmtpConfig{
+			Host:        "localhost",
+			Port:        server.port(),
+			Username:    "user",
+			Password:    "pw",
+			FromAddress: "Sentry Alert Service <alert@example.com>",
+			Security:    SmtpSecurityNone,
+		},
+	})
+	err := mail.Send("owner@example.com, second@example.com", "N0CALL-10", time.Now(), "")
+	assert.NilError(t, err)
+
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake smtp server did not finish")
+	}
+	assert.Equal(t, server.from, "alert@example.com")
+	assert.DeepEqual(t, server.recipients, []string{"owner@example.com", "second@example.com"})
+	assert.Equal(t, server.auth != "", true)
+	assert.Equal(t, strings.Contains(server.data, "Subject: N0CALL-10 appears to be down\r\n"), true)
+	assert.Equal(t, strings.Contains(server.data, "From: Sentry Alert Service <alert@example.com>\r\n"), true)
+}
+
+func TestSmtpServer_SendRecovery(t *testing.T) {
+	server := newFakeSmtpServer(t)
+	defer server.listener.Close()
+
+	mail := NewSmtpServer(Config{
+		Smtp: &SmtpConfig{
+			Host:        "127.0.0.1",
+			Port:        server.port(),
+			FromAddress: "alert@example.com",
+			Security:    SmtpSecurityNone,
+		},
+	})
+	err := mail.SendRecovery("owner@example.com", "N0CALL-10", time.Now().Add(-2*time.Hour), 2*time.Hour, "https://sentry.example.org/unsubscribe?token=abc")
+	assert.NilError(t, err)
+
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake smtp server did not finish")
+	}
+	assert.Equal(t, server.auth, "")
+	assert.Equal(t, strings.Contains(server.data, "Subject: N0CALL-10 is back online\r\n"), true)
+	assert.Equal(t, strings.Contains(server.data, "down for 2h0m0s"), true)
+	assert.Equal(t, strings.Contains(server.data, "https://sentry.example.org/unsubscribe?token=abc"), true)
+}
+
+func TestNewMail_RequiresOneBackend(t *testing.T) {
+	_, err := NewMail(Config{})
+	assert.Error(t, err, "one mail backend")
+
+	_, err = NewMail(Config{Mailgun: &MailgunConfig{}, Smtp: &SmtpConfig{}})
+	assert.Error(t, err, "one mail backend")
+}
+
+func TestSmtpMessage_FoldsHeaderLineBreaks(t *testing.T) {
+	mailer := &smtpMailer{fromAddress: "sentry@example.com"}
+	message := string(mailer.message([]string{"owner@example.com"}, "N0CALL\r\nBcc: victim@example.com", "body"))
+	assert.Equal(t, strings.Contains(message, "\r\nBcc:"), false)
+	assert.Contains(t, message, "Subject: N0CALL  Bcc: victim@example.com\r\n")
+}
@@ -0,0 +1,179 @@
+package sentrylib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for subscription tokens that are malformed,
+// carry a bad signature or have expired.
+var ErrInvalidToken = errors.New("Invalid or expired link")
+
+const (
+	tokenConfirm     = "confirm"
+	tokenUnsubscribe = "unsubscribe"
+)
+
+// Subscriptions lets owners add and remove their own address without an
+// admin. Subscribe mails a signed confirmation link; the address is only
+// stored once Confirm is called with the token from that link. Unsubscribe
+// tokens never expire so links in old alert mails keep working.
+type Subscriptions interface {
+	Subscribe(callsign, email string) error
+	Confirm(token string) (string, string, error)
+	Unsubscribe(token string) (string, string, error)
+	UnsubscribeLink(callsign, email string) string
+}
+
+type subscriptions struct {
+	store          sentry_store.EmailAddressStore
+	mail           Mail
+	publicUrl      string
+	secret         []byte
+	confirmTimeout time.Duration
+}
+
+func NewSubscriptions(config Config, store sentry_store.EmailAddressStore, mail Mail) (Subscriptions, error) {
+	if config.Subscription.PublicUrl == "" {
+		return nil, errors.New("Subscription.PublicUrl must be set")
+	}
+	if config.Subscription.Secret == "" {
+		return nil, errors.New("Subscription.Secret must be set")
+	}
+	if mail == nil {
+		return nil, errors.New("Subscription requires a mail backend")
+	}
+	confirmTimeout := 24 * time.Hour
+	if config.Subscription.ConfirmTimeout != "" {
+		var err error
+		confirmTimeout, err = time.ParseDuration(config.Subscription.ConfirmTimeout)
+		if err != nil {
+			return nil, errors.New("Unable to parse Subscription.ConfirmTimeout in config")
+		}
+	}
+	return &subscriptions{
+		store:          store,
+		mail:           mail,
+		publicUrl:      strings.TrimRight(config.Subscription.PublicUrl, "/"),
+		secret:         []byte(config.Subscription.Secret),
+		confirmTimeout: confirmTimeout,
+	}, nil
+}
+
+func (subs *subscriptions) Subscribe(callsign, email string) error {
+	token := subs.sign(tokenConfirm, callsign, email, time.Now().Add(subs.confirmTimeout))
+	return subs.mail.SendConfirmation(email, callsign, subs.publicUrl+"/subscribe/confirm?token="+url.QueryEscape(token))
+}
+
+func (subs *subscriptions) Confirm(token string) (string, string, error) {
+	callsign, email, err := subs.verify(tokenConfirm, token)
+	if err != nil {
+		return "", "", err
+	}
+	current, ok, err := subs.store.GetEmail(callsign)
+	if err != nil {
+		return "", "", err
+	}
+	addresses := make([]string, 0)
+	if ok {
+		addresses = splitAddresses(current)
+	}
+	if indexAddress(addresses, email) < 0 {
+		addresses = append(addresses, email)
+	}
+	return callsign, email, subs.store.AddEmail(callsign, strings.Join(addresses, ", "))
+}
+
+func (subs *subscriptions) Unsubscribe(token string) (string, string, error) {
+	callsign, email, err := subs.verify(tokenUnsubscribe, token)
+	if err != nil {
+		return "", "", err
+	}
+	current, ok, err := subs.store.GetEmail(callsign)
+	if err != nil || !ok {
+		return callsign, email, err
+	}
+	addresses := splitAddresses(current)
+	i := indexAddress(addresses, email)
+	if i < 0 {
+		return callsign, email, nil
+	}
+	addresses = append(addresses[:i], addresses[i+1:]...)
+	if len(addresses) == 0 {
+		return callsign, email, subs.store.RemoveEmail(callsign)
+	}
+	return callsign, email, subs.store.AddEmail(callsign, strings.Join(addresses, ", "))
+}
+
+func (subs *subscriptions) UnsubscribeLink(callsign, email string) string {
+	token := subs.sign(tokenUnsubscribe, callsign, email, time.Time{})
+	return subs.publicUrl + "/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// sign returns the payload and its HMAC-SHA256, each base64url encoded and
+// joined by a dot. A zero expires never expires.
+func (subs *subscriptions) sign(action, callsign, email string, expires time.Time) string {
+	var expiry int64
+	if !expires.IsZero() {
+		expiry = expires.Unix()
+	}
+	payload := strings.Join([]string{action, callsign, email, strconv.FormatInt(expiry, 10)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(subs.mac(encoded))
+}
+
+func (subs *subscriptions) verify(action, token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, subs.mac(parts[0])) {
+		return "", "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 4 || fields[0] != action {
+		return "", "", ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || (expiry != 0 && time.Now().Unix() > expiry) {
+		return "", "", ErrInvalidToken
+	}
+	return fields[1], fields[2], nil
+}
+
+func (subs *subscriptions) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, subs.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// indexAddress finds email in addresses, ignoring case and display names.
+func indexAddress(addresses []string, email string) int {
+	want := bareAddress(email)
+	for i, address := range addresses {
+		if bareAddress(address) == want {
+			return i
+		}
+	}
+	return -1
+}
+
+func bareAddress(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,265 @@
+package sentrylib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sentMail struct {
+	email, callsign, link string
+}
+
+type fakeMail struct {
+	sent []sentMail
+}
+
+func (mail *fakeMail) Send(email, callsign string, ts time.Time, unsubscribe string) error {
+	mail.sent = append(mail.sent, sentMail{email, callsign, unsubscribe})
+	return nil
+}
+
+func (mail *fakeMail) SendRecovery(email, callsign string, ts time.Time, outage time.Duration, unsubscribe string) error {
+	mail.sent = append(mail.sent, sentMail{email, callsign, unsubscribe})
+	return nil
+}
+
+func (mail *fakeMail) SendConfirmation(email, callsign, link string) error {
+	mail.sent = append(mail.sent, sentMail{email, callsign, link})
+	return nil
+}
+
+func newTestSubscriptions(t *testing.T, timeout string) (*subscriptions, *fakeMail) {
+	mail := &fakeMail{}
+	config := Config{Subscription: &SubscriptionConfig{
+		PublicUrl:      "https://sentry.example.org/",
+		Secret:         "secret",
+		ConfirmTimeout: timeout,
+	}}
+	subs, err := NewSubscriptions(config, sentry_memory.NewMemoryStore(), mail)
+	assert.NilError(t, err)
+	return subs.(*subscriptions), mail
+}
+
+func linkToken(t *testing.T, link string) string {
+	u, err := url.Parse(link)
+	assert.NilError(t, err)
+	return u.Query().Get("token")
+}
+
+func TestSubscriptions_Confirm(t *testing.T) {
+	subs, mail := newTestSubscriptions(t, "")
+	assert.NilError(t, subs.store.AddEmail("N0CALL-10", "first@example.com"))
+
+	assert.NilError(t, subs.Subscribe("N0CALL-10", "owner@example.com"))
+	assert.Equal(t, len(mail.sent), 1)
+	assert.Equal(t, mail.sent[0].email, "owner@example.com")
+	assert.Equal(t, strings.HasPrefix(mail.sent[0].link, "https://sentry.example.org/subscribe/confirm?token="), true)
+
+	// nothing is stored until the link is followed
+	email, _, err := subs.store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "first@example.com")
+
+	callsign, address, err := subs.Confirm(linkToken(t, mail.sent[0].link))
+	assert.NilError(t, err)
+	assert.Equal(t, callsign, "N0CALL-10")
+	assert.Equal(t, address, "owner@example.com")
+	email, _, err = subs.store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "first@example.com, owner@example.com")
+
+	// confirming twice does not add the address again
+	_, _, err = subs.Confirm(linkToken(t, mail.sent[0].link))
+	assert.NilError(t, err)
+	email, _, err = subs.store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "first@example.com, owner@example.com")
+}
+
+func TestSubscriptions_Unsubscribe(t *testing.T) {
+	subs, _ := newTestSubscriptions(t, "")
+	assert.NilError(t, subs.store.AddEmail("N0CALL-10", "first@example.com, Owner <OWNER@example.com>"))
+
+	_, _, err := subs.Unsubscribe(linkToken(t, subs.UnsubscribeLink("N0CALL-10", "owner@example.com")))
+	assert.NilError(t, err)
+	email, _, err := subs.store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "first@example.com")
+
+	_, _, err = subs.Unsubscribe(linkToken(t, subs.UnsubscribeLink("N0CALL-10", "first@example.com")))
+	assert.NilError(t, err)
+	_, ok, err := subs.store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+}
+
+func TestSubscriptions_RejectsBadTokens(t *testing.T) {
+	subs, mail := newTestSubscriptions(t, "-1s")
+	assert.NilError(t, subs.Subscribe("N0CALL-10", "owner@example.com"))
+	expired := linkToken(t, mail.sent[0].link)
+	_, _, err := subs.Confirm(expired)
+	assert.Equal(t, err, ErrInvalidToken)
+
+	unsubscribe := linkToken(t, subs.UnsubscribeLink("N0CALL-10", "owner@example.com"))
+	_, _, err = subs.Confirm(unsubscribe)
+	assert.Equal(t, err, ErrInvalidToken)
+
+	tampered := subs.sign(tokenConfirm, "N0CALL-11", "owner@example.com", time.Now().Add(time.Hour))
+	parts := strings.Split(tampered, ".")
+	_, _, err = subs.Confirm(parts[0] + "." + strings.Split(unsubscribe, ".")[1])
+	assert.Equal(t, err, ErrInvalidToken)
+
+	_, _, err = subs.Unsubscribe("garbage")
+	assert.Equal(t, err, ErrInvalidToken)
+}
+
+func TestNewSubscriptions_RequiresConfig(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	_, err := NewSubscriptions(Config{Subscription: &SubscriptionConfig{Secret: "secret"}}, store, &fakeMail{})
+	assert.Error(t, err, "PublicUrl")
+	_, err = NewSubscriptions(Config{Subscription: &SubscriptionConfig{PublicUrl: "https://sentry.example.org"}}, store, &fakeMail{})
+	assert.Error(t, err, "Secret")
+	_, err = NewSubscriptions(Config{Subscription: &SubscriptionConfig{PublicUrl: "https://sentry.example.org", Secret: "secret"}}, store, nil)
+	assert.Error(t, err, "mail backend")
+}
+
+func TestMailNotifier_UnsubscribeLinks(t *testing.T) {
+	subs, mail := newTestSubscriptions(t, "")
+	assert.NilError(t, subs.store.AddEmail("N0CALL-10", "first@example.com, second@example.com"))
+
+	notifier := NewMailNotifier(subs.store, mail, subs)
+	err := notifier.Notify(context.Background(), Notification{Callsign: "N0CALL-10", State: StateDown, LastSeen: time.Now()})
+	assert.NilError(t, err)
+	assert.Equal(t, len(mail.sent), 2)
+	assert.Equal(t, mail.sent[0].email, "first@example.com")
+	assert.Equal(t, mail.sent[0].link, subs.UnsubscribeLink("N0CALL-10", "first@example.com"))
+	assert.Equal(t, mail.sent[1].email, "second@example.com")
+	assert.Equal(t, mail.sent[1].link, subs.UnsubscribeLink("N0CALL-10", "second@example.com"))
+}
+
+// postForm sends form to the public server from remote.
+func postForm(ws webServer, path, remote string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.RemoteAddr = remote
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebServer_Subscribe(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	mail := &fakeMail{}
+	subs, err := NewSubscriptions(Config{Subscription: &SubscriptionConfig{PublicUrl: "https://sentry.example.org", Secret: "secret"}}, store, mail)
+	assert.NilError(t, err)
+	ws := newTestWebServer(t, store, subs)
+	remote := "192.0.2.1:1234"
+
+	rec := postForm(ws, "/subscribe", remote, url.Values{"callsign": {" n0call-10 "}, "email": {"Owner <owner@example.com>"}})
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, len(mail.sent), 1)
+	assert.Equal(t, mail.sent[0].email, "owner@example.com")
+	assert.Equal(t, mail.sent[0].callsign, "N0CALL-10")
+
+	// following the link only shows a form, so link scanners change nothing
+	u, err := url.Parse(mail.sent[0].link)
+	assert.NilError(t, err)
+	rec = httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", u.RequestURI(), nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(), `action="/subscribe/confirm"`)
+	_, ok, err := store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	rec = postForm(ws, "/subscribe/confirm", remote, u.Query())
+	assert.Equal(t, rec.Code, http.StatusOK)
+	email, _, err := store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "owner@example.com")
+
+	u, err = url.Parse(subs.UnsubscribeLink("N0CALL-10", "owner@example.com"))
+	assert.NilError(t, err)
+	rec = httptest.NewRecorder()
+	ws.public.Handler.ServeHTTP(rec, httptest.NewRequest("GET", u.RequestURI(), nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Contains(t, rec.Body.String(), `action="/unsubscribe"`)
+	_, ok, err = store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	rec = postForm(ws, "/unsubscribe", remote, u.Query())
+	assert.Equal(t, rec.Code, http.StatusOK)
+	_, ok, err = store.GetEmail("N0CALL-10")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	rec = postForm(ws, "/unsubscribe", remote, url.Values{"token": {"garbage"}})
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestWebServer_SubscribeRejectsBadInput(t *testing.T) {
+	subs, mail := newTestSubscriptions(t, "")
+	ws := newTestWebServer(t, sentry_memory.NewMemoryStore(), subs)
+
+	for _, form := range []url.Values{
+		{"callsign": {"N0CALL-10"}, "email": {"not an address"}},
+		{"callsign": {"N0CALL-10"}, "email": {"owner@example.com\r\nBcc: victim@example.com"}},
+		{"callsign": {"N0CALL-10\r\nBcc: victim@example.com"}, "email": {"owner@example.com"}},
+	} {
+		rec := postForm(ws, "/subscribe", "192.0.2.1:1234", form)
+		assert.Equal(t, rec.Code, http.StatusBadRequest)
+	}
+	assert.Equal(t, len(mail.sent), 0)
+}
+
+func TestWebServer_SubscribeRateLimits(t *testing.T) {
+	subs, mail := newTestSubscriptions(t, "")
+	ws := newTestWebServer(t, sentry_memory.NewMemoryStore(), subs)
+
+	// one recipient is only mailed a few times, whoever asks
+	for i := 0; i < subscribeLimitPerAddress; i++ {
+		rec := postForm(ws, "/subscribe", "192.0.2.1:1234", url.Values{"callsign": {"N0CALL-10"}, "email": {"owner@example.com"}})
+		assert.Equal(t, rec.Code, http.StatusOK)
+	}
+	rec := postForm(ws, "/subscribe", "192.0.2.2:1234", url.Values{"callsign": {"N0CALL-10"}, "email": {"Owner@Example.com"}})
+	assert.Equal(t, rec.Code, http.StatusTooManyRequests)
+	assert.Equal(t, len(mail.sent), subscribeLimitPerAddress)
+
+	// one client cannot mail many recipients
+	for i := subscribeLimitPerAddress; i < subscribeLimitPerIP; i++ {
+		rec := postForm(ws, "/subscribe", "192.0.2.1:5678", url.Values{"callsign": {"N0CALL-10"}, "email": {fmt.Sprintf("owner%d@example.com", i)}})
+		assert.Equal(t, rec.Code, http.StatusOK)
+	}
+	rec = postForm(ws, "/subscribe", "192.0.2.1:1234", url.Values{"callsign": {"N0CALL-10"}, "email": {"other@example.com"}})
+	assert.Equal(t, rec.Code, http.StatusTooManyRequests)
+	assert.Equal(t, rec.Header().Get("Retry-After"), "3600")
+	assert.Equal(t, len(mail.sent), subscribeLimitPerIP)
+}
+
+// failingMail fails every send.
+type failingMail struct {
+	fakeMail
+}
+
+func (mail *failingMail) SendConfirmation(email, callsign, link string) error {
+	return errors.New("connection refused")
+}
+
+func TestWebServer_SubscribeMailFailure(t *testing.T) {
+	subs, err := NewSubscriptions(Config{Subscription: &SubscriptionConfig{PublicUrl: "https://sentry.example.org", Secret: "secret"}}, sentry_memory.NewMemoryStore(), &failingMail{})
+	assert.NilError(t, err)
+	ws := newTestWebServer(t, sentry_memory.NewMemoryStore(), subs)
+
+	rec := postForm(ws, "/subscribe", "192.0.2.1:1234", url.Values{"callsign": {"N0CALL-10"}, "email": {"owner@example.com"}})
+	assert.Equal(t, rec.Code, http.StatusInternalServerError)
+}
@@ -0,0 +1,137 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// callsignPattern matches an APRS-IS source callsign: letters or digits,
+// optionally followed by an SSID of one or two letters or digits. Unlike
+// AX.25, APRS-IS does not limit the base callsign to six characters, only the
+// whole callsign-SSID to maxCallsignLength.
+var callsignPattern = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]{1,2})?$`)
+
+const maxCallsignLength = 9
+
+var (
+	ErrInvalidCallsign = errors.New("Callsign must be up to nine letters or digits including an optional SSID, such as N0CALL-10")
+	ErrInvalidEmail    = errors.New("Email must be a valid address such as owner@example.com")
+)
+
+// normalizeCallsign trims and upper-cases callsign the way it appears in
+// APRS-IS frames, and rejects anything that cannot be a callsign-SSID.
+func normalizeCallsign(callsign string) (string, error) {
+	callsign = strings.ToUpper(strings.TrimSpace(callsign))
+	if len(callsign) > maxCallsignLength || !callsignPattern.MatchString(callsign) {
+		return "", ErrInvalidCallsign
+	}
+	return callsign, nil
+}
+
+// normalizeAddress reduces a single address, possibly with a display name, to
+// the bare address with a lower-case domain.
+func normalizeAddress(address string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	if at <= 0 || at == len(addr.Address)-1 {
+		return "", ErrInvalidEmail
+	}
+	return addr.Address[:at] + strings.ToLower(addr.Address[at:]), nil
+}
+
+// normalizeAddresses validates the comma separated list stored by AddEmail,
+// normalizes every address and drops duplicates.
+func normalizeAddresses(email string) (string, error) {
+	addresses := make([]string, 0)
+	for _, v := range splitAddresses(email) {
+		address, err := normalizeAddress(v)
+		if err != nil {
+			return "", err
+		}
+		if indexAddress(addresses, address) < 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return "", ErrInvalidEmail
+	}
+	return strings.Join(addresses, ", "), nil
+}
+
+// maxBodySize limits admin API request bodies.
+const maxBodySize = 64 << 10
+
+// readValue reads a single value from an admin API request. JSON bodies are
+// objects and the value is taken from field, e.g. {"email": "..."}; any
+// other body is the value itself as plain text.
+func readValue(r *http.Request, field string) (string, error) {
+	body := io.LimitReader(r.Body, maxBodySize+1)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		values := map[string]interface{}{}
+		if err := json.NewDecoder(body).Decode(&values); err != nil {
+			return "", errors.New("Unable to parse JSON body: " + err.Error())
+		}
+		value, ok := values[field].(string)
+		if !ok {
+			return "", errors.New("JSON body must have a string field '" + field + "'")
+		}
+		return value, nil
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) > maxBodySize {
+		return "", errors.New("Request body is too large")
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// apiError is the body of every admin API error response.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError sends a JSON error. code is a stable, machine readable name such
+// as "invalid_callsign"; message is meant for people.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	res, _ := json.MarshalIndent(apiError{apiErrorDetail{status, code, message}}, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// writeJSON sends v as an indented JSON body, or a 500 if it cannot be
+// encoded.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status other than 200.
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	res, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
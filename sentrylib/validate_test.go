@@ -0,0 +1,196 @@
+package sentrylib
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCallsign(t *testing.T) {
+	for input, expected := range map[string]string{
+		"N0CALL":       "N0CALL",
+		" n0call-10\n": "N0CALL-10",
+		"kj6abc-Y":     "KJ6ABC-Y",
+		"TOOLONG1":     "TOOLONG1",
+		"ab1cdef-1":    "AB1CDEF-1",
+	} {
+		callsign, err := normalizeCallsign(input)
+		assert.NilError(t, err)
+		assert.Equal(t, callsign, expected)
+	}
+	for _, input := range []string{"", "N0CALL-", "N0CALL-100", "TOOLONGCALL", "TOOLONG1-15", "N0 CALL", "N0CALL/P", "-1"} {
+		_, err := normalizeCallsign(input)
+		assert.Equal(t, err, ErrInvalidCallsign)
+	}
+}
+
+func TestNormalizeAddresses(t *testing.T) {
+	email, err := normalizeAddresses("Owner <Owner@Example.COM>\n")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "Owner@example.com")
+
+	email, err = normalizeAddresses("a@example.com, b@example.com,a@EXAMPLE.com")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "a@example.com, b@example.com")
+
+	for _, input := range []string{"", " , ", "owner", "owner@example.com, nope"} {
+		_, err := normalizeAddresses(input)
+		assert.Equal(t, err, ErrInvalidEmail)
+	}
+}
+
+// failingEmailStore fails every email write.
+type failingEmailStore struct {
+	sentry_store.Store
+}
+
+func (store failingEmailStore) AddEmail(callsign, email string) error {
+	return errors.New("disk full")
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiErrorDetail {
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/json")
+	body := apiError{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, body.Error.Status, rec.Code)
+	return body.Error
+}
+
+func TestAddEmail(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	local := "127.0.0.1:5555"
+
+	rec := adminRequest(ws, "PUT", "/email/n0call-1", "", local, strings.NewReader("owner@example.com\n"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	email, ok, err := store.GetEmail("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, email, "owner@example.com")
+
+	req := httptest.NewRequest("PUT", "/email/N0CALL-2", strings.NewReader(`{"email": "Owner <owner@Example.com>"}`))
+	req.RemoteAddr = local
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec = httptest.NewRecorder()
+	ws.admin.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	stored := sentry_store.CallsignEmail{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &stored))
+	assert.DeepEqual(t, stored, sentry_store.CallsignEmail{Callsign: "N0CALL-2", Email: "owner@example.com"})
+
+	rec = adminRequest(ws, "GET", "/email/n0call-2", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/json")
+	stored = sentry_store.CallsignEmail{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &stored))
+	assert.DeepEqual(t, stored, sentry_store.CallsignEmail{Callsign: "N0CALL-2", Email: "owner@example.com"})
+	rec = adminRequest(ws, "GET", "/email/N0CALL-3", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+	assert.Equal(t, decodeError(t, rec).Code, "not_found")
+}
+
+func TestAddEmail_Rejects(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	local := "127.0.0.1:5555"
+
+	rec := adminRequest(ws, "PUT", "/email/N0CALL-100", "", local, strings.NewReader("owner@example.com"))
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+	assert.Equal(t, decodeError(t, rec).Code, "invalid_callsign")
+
+	rec = adminRequest(ws, "PUT", "/email/N0CALL-1", "", local, strings.NewReader("not an address"))
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+	assert.Equal(t, decodeError(t, rec).Code, "invalid_email")
+
+	req := httptest.NewRequest("PUT", "/email/N0CALL-1", strings.NewReader(`{"mail": "owner@example.com"}`))
+	req.RemoteAddr = local
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	ws.admin.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+	detail := decodeError(t, rec)
+	assert.Equal(t, detail.Code, "invalid_body")
+	assert.Contains(t, detail.Message, "'email'")
+
+	emails, err := store.ListEmail()
+	assert.NilError(t, err)
+	assert.Equal(t, len(emails), 0)
+
+	ws = newTestWebServer(t, failingEmailStore{store}, nil)
+	rec = adminRequest(ws, "PUT", "/email/N0CALL-1", "", local, strings.NewReader("owner@example.com"))
+	assert.Equal(t, rec.Code, http.StatusInternalServerError)
+	assert.Equal(t, decodeError(t, rec).Message, "disk full")
+	audit, err := store.ListAudit(time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(audit), 0)
+}
+
+func TestAddCutoff_JSON(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+
+	req := httptest.NewRequest("PUT", "/cutoff/n0call-1", strings.NewReader(`{"cutoff": "6h"}`))
+	req.RemoteAddr = "127.0.0.1:5555"
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ws.admin.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	cutoff, ok, err := store.GetCutoff("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, cutoff, 6*time.Hour)
+
+	rec = adminRequest(ws, "PUT", "/cutoff/N0CALL-1", "", "127.0.0.1:5555", strings.NewReader("-1h"))
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+	assert.Equal(t, decodeError(t, rec).Code, "invalid_cutoff")
+}
+
+func TestAdmin_LegacyKeys(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	ws := newTestWebServer(t, store, nil)
+	local := "127.0.0.1:5555"
+	// stored under the raw path before callsigns were normalized
+	assert.NilError(t, store.AddEmail("n0call-1", "old@example.com"))
+	assert.NilError(t, store.AddEmail("n0call-2", "old@example.com"))
+	assert.NilError(t, store.AddCutoff("n0call-1", time.Hour))
+
+	rec := adminRequest(ws, "GET", "/email/n0call-1", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	stored := sentry_store.CallsignEmail{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &stored))
+	assert.DeepEqual(t, stored, sentry_store.CallsignEmail{Callsign: "N0CALL-1", Email: "old@example.com"})
+	rec = adminRequest(ws, "GET", "/cutoff/n0call-1", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	cutoff := callsignCutoffView{}
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &cutoff))
+	assert.Equal(t, cutoff, callsignCutoffView{"N0CALL-1", "1h0m0s"})
+
+	// writing moves the value to the normalized key
+	rec = adminRequest(ws, "PUT", "/email/n0call-1", "", local, strings.NewReader("new@example.com"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	_, ok, err := store.GetEmail("n0call-1")
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	email, _, err := store.GetEmail("N0CALL-1")
+	assert.NilError(t, err)
+	assert.Equal(t, email, "new@example.com")
+
+	// deleting removes both
+	rec = adminRequest(ws, "DELETE", "/email/n0call-2", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	rec = adminRequest(ws, "DELETE", "/cutoff/n0call-1", "", local, nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	emails, err := store.ListEmail()
+	assert.NilError(t, err)
+	assert.Equal(t, len(emails), 1)
+	cutoffs, err := store.ListCutoff()
+	assert.NilError(t, err)
+	assert.Equal(t, len(cutoffs), 0)
+}
@@ -0,0 +1,116 @@
+package sentrylib
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPublicAddress = ":8080"
+	defaultAdminAddress  = "127.0.0.1:8081"
+
+	// unixPrefix marks an Address as a Unix socket path.
+	unixPrefix = "unix:"
+)
+
+// webListener is where one of the HTTP servers listens and how.
+type webListener struct {
+	name      string
+	network   string
+	address   string
+	tlsConfig *tls.Config
+}
+
+// newHTTPServer applies config to a server for handler. name is used in
+// error messages, e.g. "Web.Public".
+func newHTTPServer(name string, config *ListenerConfig, defaultAddress string, handler http.Handler) (*http.Server, webListener, error) {
+	if config == nil {
+		config = &ListenerConfig{}
+	}
+	listener := webListener{name: name, network: "tcp", address: config.Address}
+	if listener.address == "" {
+		listener.address = defaultAddress
+	}
+	if strings.HasPrefix(listener.address, unixPrefix) {
+		listener.network = "unix"
+		listener.address = strings.TrimPrefix(listener.address, unixPrefix)
+	}
+
+	if config.TlsCert != "" || config.TlsKey != "" {
+		if config.TlsCert == "" || config.TlsKey == "" {
+			return nil, listener, errors.New(name + ".TlsCert and " + name + ".TlsKey must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TlsCert, config.TlsKey)
+		if err != nil {
+			return nil, listener, errors.New("Unable to load " + name + " TLS certificate: " + err.Error())
+		}
+		listener.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	server := &http.Server{Addr: listener.address, Handler: handler}
+	var err error
+	if server.ReadTimeout, err = listenerTimeout(config.ReadTimeout, 10*time.Second, name+".ReadTimeout"); err != nil {
+		return nil, listener, err
+	}
+	if server.WriteTimeout, err = listenerTimeout(config.WriteTimeout, 30*time.Second, name+".WriteTimeout"); err != nil {
+		return nil, listener, err
+	}
+	if server.IdleTimeout, err = listenerTimeout(config.IdleTimeout, 2*time.Minute, name+".IdleTimeout"); err != nil {
+		return nil, listener, err
+	}
+	return server, listener, nil
+}
+
+func listenerTimeout(value string, defaultTimeout time.Duration, name string) (time.Duration, error) {
+	timeout, err := parseOptionalDuration(value, name)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return timeout, nil
+}
+
+// listen opens the socket. A leftover Unix socket from an earlier run is
+// removed first, and the new one is only accessible to its owner and group.
+func (listener webListener) listen() (net.Listener, error) {
+	if listener.network == "unix" {
+		if info, err := os.Stat(listener.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(listener.address)
+		}
+	}
+	var l net.Listener
+	var err error
+	if listener.network == "unix" {
+		l, err = listenUnix(listener.address)
+	} else {
+		l, err = net.Listen(listener.network, listener.address)
+	}
+	if err != nil {
+		return nil, errors.New(listener.name + ": " + err.Error())
+	}
+	if listener.tlsConfig != nil {
+		l = tls.NewListener(l, listener.tlsConfig)
+	}
+	return l, nil
+}
+
+func (listener webListener) String() string {
+	scheme := "http"
+	if listener.tlsConfig != nil {
+		scheme = "https"
+	}
+	if listener.network == "unix" {
+		return scheme + " on unix socket " + listener.address
+	}
+	return scheme + " on " + listener.address
+}
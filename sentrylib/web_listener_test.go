@@ -0,0 +1,149 @@
+package sentrylib
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"github.com/fkautz/sentry/sentrylib/sentry_store/sentry_memory"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and returns
+// the cert and key paths.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sentry test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NilError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NilError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NilError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startWebServer runs a web server built from config and returns a function
+// that stops it and returns the error from Serve.
+func startWebServer(t *testing.T, config Config) func() error {
+	ws, err := NewWebServer(config, sentry_memory.NewMemoryStore(), nil, nil)
+	assert.NilError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ws.Serve(ctx)
+	}()
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func waitForGet(t *testing.T, client *http.Client, url string) *http.Response {
+	var err error
+	for i := 0; i < 50; i++ {
+		var res *http.Response
+		res, err = client.Get(url)
+		if err == nil {
+			return res
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal(err)
+	return nil
+}
+
+func TestWebServer_TLSAndUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentry-web")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+	socket := filepath.Join(dir, "admin.sock")
+	public := freeAddress(t)
+
+	stop := startWebServer(t, Config{Web: &WebConfig{
+		Public: &ListenerConfig{Address: public, TlsCert: certFile, TlsKey: keyFile, ReadTimeout: "5s"},
+		Admin:  &ListenerConfig{Address: "unix:" + socket},
+	}})
+
+	insecure := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	res := waitForGet(t, insecure, "https://"+public+"/api/live")
+	res.Body.Close()
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	unix := &http.Client{Transport: &http.Transport{Dial: func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", socket)
+	}}}
+	res = waitForGet(t, unix, "http://admin/email")
+	res.Body.Close()
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	info, err := os.Stat(socket)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0660))
+
+	assert.NilError(t, stop())
+}
+
+func TestWebServer_ServeReturnsListenErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+
+	ws, err := NewWebServer(Config{Web: &WebConfig{
+		Public: &ListenerConfig{Address: l.Addr().String()},
+		Admin:  &ListenerConfig{Address: freeAddress(t)},
+	}}, sentry_memory.NewMemoryStore(), nil, nil)
+	assert.NilError(t, err)
+	err = ws.Serve(context.Background())
+	assert.Error(t, err, "Web.Public")
+}
+
+func TestNewWebServer_RejectsBadConfig(t *testing.T) {
+	store := sentry_memory.NewMemoryStore()
+	_, err := NewWebServer(Config{Web: &WebConfig{Public: &ListenerConfig{TlsCert: "cert.pem"}}}, store, nil, nil)
+	assert.Error(t, err, "must be set together")
+	_, err = NewWebServer(Config{Web: &WebConfig{Admin: &ListenerConfig{IdleTimeout: "soon"}}}, store, nil, nil)
+	assert.Error(t, err, "Web.Admin.IdleTimeout")
+	_, err = NewWebServer(Config{Web: &WebConfig{Public: &ListenerConfig{TlsCert: "missing.pem", TlsKey: "missing.pem"}}}, store, nil, nil)
+	assert.Error(t, err, "Unable to load Web.Public TLS certificate")
+}
+
+func TestNewWebServer_Defaults(t *testing.T) {
+	ws := newTestWebServer(t, sentry_memory.NewMemoryStore(), nil)
+	assert.Equal(t, ws.public.Addr, ":8080")
+	assert.Equal(t, ws.admin.Addr, "127.0.0.1:8081")
+	assert.Equal(t, ws.public.ReadTimeout, 10*time.Second)
+	assert.Equal(t, ws.public.WriteTimeout, 30*time.Second)
+	assert.Equal(t, ws.admin.IdleTimeout, 2*time.Minute)
+}
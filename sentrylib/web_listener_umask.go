@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package sentrylib
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskLock serializes listenUnix, since the umask is process wide. The
+// store is already open by the time the web servers listen, so nothing else
+// should be creating files in that moment.
+var umaskLock sync.Mutex
+
+// listenUnix creates the socket at address with mode 0660. Setting the umask
+// around the call means the socket never exists with looser permissions, not
+// even between creating and chmod'ing it.
+func listenUnix(address string) (net.Listener, error) {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	old := syscall.Umask(0117)
+	defer syscall.Umask(old)
+	return net.Listen("unix", address)
+}
@@ -0,0 +1,9 @@
+package sentrylib
+
+import "net"
+
+// listenUnix creates the socket at address. Windows has no umask; access to
+// the socket follows the ACL of its directory.
+func listenUnix(address string) (net.Listener, error) {
+	return net.Listen("unix", address)
+}
@@ -1,33 +1,152 @@
 package sentrylib
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/fkautz/sentry/sentrylib/sentry_store"
 	"github.com/gorilla/mux"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
 
+type WebServer interface {
+	Serve(ctx context.Context) error
+}
+
 type webServer struct {
-	store sentry_store.Store
+	store         sentry_store.Store
+	subscriptions Subscriptions
+	// subscribeByIP and subscribeByAddress limit how many confirmation mails
+	// /subscribe sends per client and per recipient.
+	subscribeByIP      *rateLimiter
+	subscribeByAddress *rateLimiter
+	public             *http.Server
+	admin              *http.Server
+	listeners          []webListener
 }
 
-func NewWebServer(store sentry_store.Store) {
+// NewWebServer serves the public API, status dashboard, metrics and health
+// checks on :8080 and the token protected email admin API on 127.0.0.1:8081,
+// unless config.Web says otherwise. Self-service subscription pages are only served
+// if subscriptions is not nil. client is only used for metrics and health
+// checks and may be nil.
+func NewWebServer(config Config, store sentry_store.Store, client AprsClient, subscriptions Subscriptions) (WebServer, error) {
+	health, err := newHealthChecker(config, store, client)
+	if err != nil {
+		return nil, err
+	}
+
 	router := mux.NewRouter()
-	ws := webServer{store: store}
+	ws := webServer{store: store, subscriptions: subscriptions}
 	router.HandleFunc("/api/dead", ws.findDead).Methods("GET")
 	router.HandleFunc("/api/live", ws.findLive).Methods("GET")
 	router.HandleFunc("/api/node/{node}", ws.findNode).Methods("GET")
-	go http.ListenAndServe(":8080", router)
+	router.HandleFunc("/api/node/{node}/history", ws.findHistory).Methods("GET")
+	router.HandleFunc("/api/live.geojson", ws.liveGeoJSON).Methods("GET")
+	router.HandleFunc("/api/dead.geojson", ws.deadGeoJSON).Methods("GET")
+	router.HandleFunc("/api/nodes.kml", ws.nodesKML).Methods("GET")
+	router.HandleFunc("/api/sentry.kml", ws.networkLinkKML).Methods("GET")
+	router.HandleFunc("/", ws.dashboard).Methods("GET")
+	router.HandleFunc("/node/{node}", ws.dashboardNode).Methods("GET")
+	router.HandleFunc("/map", ws.dashboardMap).Methods("GET")
+	router.Handle("/metrics", newMetricsHandler(store, client)).Methods("GET")
+	router.HandleFunc("/healthz", health.healthz).Methods("GET")
+	router.HandleFunc("/readyz", health.readyz).Methods("GET")
+	if subscriptions != nil {
+		ws.subscribeByIP = newRateLimiter(subscribeLimitPerIP, time.Hour)
+		ws.subscribeByAddress = newRateLimiter(subscribeLimitPerAddress, time.Hour)
+		router.HandleFunc("/subscribe", ws.subscribeForm).Methods("GET")
+		router.HandleFunc("/subscribe", ws.subscribe).Methods("POST")
+		router.HandleFunc("/subscribe/confirm", ws.confirmForm).Methods("GET")
+		router.HandleFunc("/subscribe/confirm", ws.confirmSubscription).Methods("POST")
+		router.HandleFunc("/unsubscribe", ws.unsubscribeForm).Methods("GET")
+		router.HandleFunc("/unsubscribe", ws.unsubscribe).Methods("POST")
+	}
+	web := config.Web
+	if web == nil {
+		web = &WebConfig{}
+	}
+	var public webListener
+	ws.public, public, err = newHTTPServer("Web.Public", web.Public, defaultPublicAddress, router)
+	if err != nil {
+		return nil, err
+	}
 
 	router = mux.NewRouter()
 	router.HandleFunc("/email", ws.listEmail).Methods("GET")
 	router.HandleFunc("/email/{node}", ws.getEmailForNode).Methods("GET")
 	router.HandleFunc("/email/{node}", ws.addEmail).Methods("PUT")
 	router.HandleFunc("/email/{node}", ws.removeEmail).Methods("DELETE")
-	go http.ListenAndServe("127.0.0.1:8081", router)
+	router.HandleFunc("/cutoff", ws.listCutoff).Methods("GET")
+	router.HandleFunc("/cutoff/{node}", ws.getCutoffForNode).Methods("GET")
+	router.HandleFunc("/cutoff/{node}", ws.addCutoff).Methods("PUT")
+	router.HandleFunc("/cutoff/{node}", ws.removeCutoff).Methods("DELETE")
+	router.HandleFunc("/audit", ws.listAudit).Methods("GET")
+	router.HandleFunc("/tokens", ws.listTokens).Methods("GET")
+	router.HandleFunc("/tokens", ws.createToken).Methods("POST")
+	router.HandleFunc("/tokens/{name}", ws.revokeToken).Methods("DELETE")
+	auth := adminAuth{store: store, requireToken: web.RequireAdminToken, handler: router}
+	var admin webListener
+	ws.admin, admin, err = newHTTPServer("Web.Admin", web.Admin, defaultAdminAddress, auth)
+	if err != nil {
+		return nil, err
+	}
+	ws.listeners = []webListener{public, admin}
+	return ws, nil
+}
+
+// Serve runs both servers until ctx is done or one of them fails, then gives
+// in-flight requests a few seconds to finish. It returns the first error,
+// including failures to listen.
+func (s webServer) Serve(ctx context.Context) error {
+	servers := []*http.Server{s.public, s.admin}
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, listener := range s.listeners {
+		l, err := listener.listen()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		log.Println(listener.name, "serving", listener)
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		go func(server *http.Server, l net.Listener) {
+			err := server.Serve(l)
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			errs <- err
+		}(server, listeners[i])
+	}
+
+	var err error
+	running := len(servers)
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+		running--
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); err == nil {
+			err = shutdownErr
+		}
+	}
+	for ; running > 0; running-- {
+		if serveErr := <-errs; err == nil {
+			err = serveErr
+		}
+	}
+	return err
 }
 
 func (s webServer) findLive(w http.ResponseWriter, r *http.Request) {
@@ -62,9 +181,12 @@ func (s webServer) findDead(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
+// CallsignTimeLive is returned by /api/node/{node}. Node holds the metadata
+// from the last position report, if one was stored.
 type CallsignTimeLive struct {
 	sentry_store.CallsignTime
 	SeenRecently bool
+	Node         *sentry_store.NodeInfo `json:",omitempty"`
 }
 
 func (s webServer) findNode(w http.ResponseWriter, r *http.Request) {
@@ -93,7 +215,16 @@ func (s webServer) findNode(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		ct := sentry_store.CallsignTime{callsign, ts}
-		ctl := CallsignTimeLive{ct, seenRecently}
+		ctl := CallsignTimeLive{CallsignTime: ct, SeenRecently: seenRecently}
+		node, ok, err := s.store.GetNode(callsign)
+		if err != nil {
+			w.WriteHeader(501)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if ok {
+			ctl.Node = &node
+		}
 		res, err := json.MarshalIndent(ctl, "", "    ")
 		if err != nil {
 			w.WriteHeader(501)
@@ -103,25 +234,25 @@ func (s webServer) findNode(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s webServer) addEmail(w http.ResponseWriter, r *http.Request) {
+func (s webServer) findHistory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	log.Println("ADD =====", vars, "=====")
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
+	since := time.Time{}
+	if param := r.URL.Query().Get("since"); param != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("Unable to parse since, expected RFC3339: " + err.Error()))
+			return
+		}
 	}
-	s.store.AddEmail(vars["node"], string(body))
-	log.Println("'" + string(body) + "'")
-}
-
-func (s webServer) listEmail(w http.ResponseWriter, r *http.Request) {
-	emails, err := s.store.ListEmail()
+	events, err := s.store.ListEvents(vars["node"], since)
 	if err != nil {
 		w.WriteHeader(501)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	res, err := json.MarshalIndent(emails, "", "    ")
+	res, err := json.MarshalIndent(events, "", "    ")
 	if err != nil {
 		w.WriteHeader(501)
 		w.Write([]byte(err.Error()))
@@ -130,19 +261,179 @@ func (s webServer) listEmail(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
+// adminCallsign returns the normalized {node} from the request path, or
+// writes a 400 and returns false. Before callsigns were normalized, emails and
+// cutoffs were stored under the path exactly as given, so legacy is that raw
+// key when it differs; handlers read it as a fallback and clear it when the
+// callsign is written or removed.
+func adminCallsign(w http.ResponseWriter, r *http.Request) (callsign, legacy string, ok bool) {
+	raw := mux.Vars(r)["node"]
+	callsign, err := normalizeCallsign(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_callsign", err.Error())
+		return "", "", false
+	}
+	if raw != callsign {
+		legacy = raw
+	}
+	return callsign, legacy, true
+}
+
+func (s webServer) addEmail(w http.ResponseWriter, r *http.Request) {
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	body, err := readValue(r, "email")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	email, err := normalizeAddresses(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", err.Error())
+		return
+	}
+	if err := s.store.AddEmail(callsign, email); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if legacy != "" {
+		if err := s.store.RemoveEmail(legacy); err != nil {
+			log.Println("Unable to remove legacy email key", legacy+":", err)
+		}
+	}
+	s.audit(requestActor(r), "email.add", callsign, email)
+	writeJSON(w, sentry_store.CallsignEmail{Callsign: callsign, Email: email})
+}
+
+func (s webServer) listEmail(w http.ResponseWriter, r *http.Request) {
+	emails, err := s.store.ListEmail()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	writeJSON(w, emails)
+}
+
 func (s webServer) getEmailForNode(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	email, ok, err := s.store.GetEmail(vars["node"])
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	email, ok, err := s.store.GetEmail(callsign)
+	if err == nil && !ok && legacy != "" {
+		email, ok, err = s.store.GetEmail(legacy)
+	}
 	if err != nil {
-		w.WriteHeader(501)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
 	}
-	if ok {
-		w.Write([]byte(email))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No email registered for '"+callsign+"'")
+		return
 	}
+	writeJSON(w, sentry_store.CallsignEmail{Callsign: callsign, Email: email})
 }
 
 func (s webServer) removeEmail(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	s.store.RemoveEmail(vars["node"])
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.RemoveEmail(callsign); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if legacy != "" {
+		if err := s.store.RemoveEmail(legacy); err != nil {
+			writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+			return
+		}
+	}
+	s.audit(requestActor(r), "email.remove", callsign, "")
+}
+
+type callsignCutoffView struct {
+	Callsign string
+	Cutoff   string
+}
+
+func (s webServer) addCutoff(w http.ResponseWriter, r *http.Request) {
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	body, err := readValue(r, "cutoff")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	cutoff, err := time.ParseDuration(body)
+	if err != nil || cutoff <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_cutoff", "Cutoff must be a positive duration such as '6h'")
+		return
+	}
+	if err := s.store.AddCutoff(callsign, cutoff); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if legacy != "" {
+		if err := s.store.RemoveCutoff(legacy); err != nil {
+			log.Println("Unable to remove legacy cutoff key", legacy+":", err)
+		}
+	}
+	s.audit(requestActor(r), "cutoff.add", callsign, cutoff.String())
+	writeJSON(w, callsignCutoffView{callsign, cutoff.String()})
+}
+
+func (s webServer) listCutoff(w http.ResponseWriter, r *http.Request) {
+	cutoffs, err := s.store.ListCutoff()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	views := make([]callsignCutoffView, 0, len(cutoffs))
+	for _, v := range cutoffs {
+		views = append(views, callsignCutoffView{v.Callsign, v.Cutoff.String()})
+	}
+	writeJSON(w, views)
+}
+
+func (s webServer) getCutoffForNode(w http.ResponseWriter, r *http.Request) {
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	cutoff, ok, err := s.store.GetCutoff(callsign)
+	if err == nil && !ok && legacy != "" {
+		cutoff, ok, err = s.store.GetCutoff(legacy)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No cutoff override for '"+callsign+"'")
+		return
+	}
+	writeJSON(w, callsignCutoffView{callsign, cutoff.String()})
+}
+
+func (s webServer) removeCutoff(w http.ResponseWriter, r *http.Request) {
+	callsign, legacy, ok := adminCallsign(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.RemoveCutoff(callsign); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if legacy != "" {
+		if err := s.store.RemoveCutoff(legacy); err != nil {
+			writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+			return
+		}
+	}
+	s.audit(requestActor(r), "cutoff.remove", callsign, "")
 }
@@ -0,0 +1,149 @@
+package sentrylib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cenkalti/backoff"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader carries "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the request body, keyed with WebhookConfig.Secret.
+const WebhookSignatureHeader = "X-Sentry-Signature"
+
+type webhookNotifier struct {
+	client  *http.Client
+	urls    []string
+	secret  []byte
+	retries int
+}
+
+type webhookPosition struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type webhookPayload struct {
+	Callsign      string           `json:"callsign"`
+	State         string           `json:"state"`
+	LastSeen      time.Time        `json:"last_seen"`
+	OutageSeconds int64            `json:"outage_seconds,omitempty"`
+	Position      *webhookPosition `json:"position,omitempty"`
+}
+
+func NewWebhookNotifier(config Config) (Notifier, error) {
+	if len(config.Webhook.Urls) == 0 {
+		return nil, errors.New("Webhook.Urls must not be empty")
+	}
+	timeout := 10 * time.Second
+	if config.Webhook.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(config.Webhook.Timeout)
+		if err != nil {
+			return nil, errors.New("Unable to parse Webhook.Timeout in config")
+		}
+	}
+	retries := 3
+	if config.Webhook.Retries > 0 {
+		retries = config.Webhook.Retries
+	} else if config.Webhook.Retries < 0 {
+		retries = 0
+	}
+	return &webhookNotifier{
+		client:  &http.Client{Timeout: timeout},
+		urls:    config.Webhook.Urls,
+		secret:  []byte(config.Webhook.Secret),
+		retries: retries,
+	}, nil
+}
+
+func (notifier *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	payload := webhookPayload{
+		Callsign:      notification.Callsign,
+		State:         notification.State,
+		LastSeen:      notification.LastSeen.UTC(),
+		OutageSeconds: int64(notification.Outage / time.Second),
+	}
+	if notification.Position != nil {
+		payload.Position = &webhookPosition{notification.Position.Latitude, notification.Position.Longitude}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, url := range notifier.urls {
+		err := notifier.post(ctx, url, body)
+		if err != nil {
+			log.Println("Webhook", url, "failed:", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// post delivers body to url. Network errors and 5xx or 429 responses are
+// retried up to notifier.retries times with exponential backoff, stopping
+// early if ctx is done.
+func (notifier *webhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	policy := backoff.NewExponentialBackOff()
+	policy.MaxElapsedTime = 0
+	var err error
+	for attempt := 0; attempt <= notifier.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(policy.NextBackOff()):
+			}
+		}
+		var retry bool
+		retry, err = notifier.postOnce(ctx, url, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (notifier *webhookNotifier) postOnce(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sentry-webhook")
+	if len(notifier.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(notifier.secret, body))
+	}
+	resp, err := notifier.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
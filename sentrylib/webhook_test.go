@@ -0,0 +1,84 @@
+package sentrylib
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/pkg/testutil/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier_SignsAndRetries(t *testing.T) {
+	attempts := 0
+	var payload webhookPayload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NilError(t, err)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		assert.Equal(t, signature, "sha256="+signWebhook([]byte("secret"), body))
+		assert.NilError(t, json.Unmarshal(body, &payload))
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(Config{Webhook: &WebhookConfig{
+		Urls:    []string{server.URL},
+		Secret:  "secret",
+		Retries: 2,
+	}})
+	assert.NilError(t, err)
+
+	lastSeen := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = notifier.Notify(context.Background(), Notification{
+		Callsign: "N0CALL-10",
+		State:    StateUp,
+		LastSeen: lastSeen,
+		Outage:   90 * time.Minute,
+		Position: &Position{45.5, -122.6},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, attempts, 2)
+	assert.Equal(t, payload.Callsign, "N0CALL-10")
+	assert.Equal(t, payload.State, StateUp)
+	assert.Equal(t, payload.LastSeen.Equal(lastSeen), true)
+	assert.Equal(t, payload.OutageSeconds, int64(5400))
+	assert.Equal(t, payload.Position.Latitude, 45.5)
+}
+
+func TestWebhookNotifier_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(Config{Webhook: &WebhookConfig{
+		Urls:    []string{server.URL},
+		Retries: 3,
+	}})
+	assert.NilError(t, err)
+
+	err = notifier.Notify(context.Background(), Notification{Callsign: "N0CALL", State: StateDown, LastSeen: time.Now()})
+	assert.Error(t, err, "400")
+	assert.Equal(t, attempts, 1)
+}
+
+func TestNewWebhookNotifier_Retries(t *testing.T) {
+	for configured, expected := range map[int]int{0: 3, 5: 5, -1: 0} {
+		notifier, err := NewWebhookNotifier(Config{Webhook: &WebhookConfig{
+			Urls:    []string{"http://localhost/hook"},
+			Retries: configured,
+		}})
+		assert.NilError(t, err)
+		assert.Equal(t, notifier.(*webhookNotifier).retries, expected)
+	}
+}